@@ -2,48 +2,166 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/ai"
 	"github.com/fjrt/poeai/internal/config"
 	"github.com/fjrt/poeai/internal/gateway"
 	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/soul"
 )
 
+// embedInterval is how often memories missing an embedding are embedded.
+const embedInterval = 15 * time.Minute
+
 func main() {
+	log.SetOutput(config.RedactWriter(os.Stderr))
+	var flags config.Flags
+	flags.Register(flag.CommandLine)
+	flag.Parse()
+	configPath := flags.Path
 	home, _ := os.UserHomeDir()
-	configPath := filepath.Join(home, ".poe", "config.toml")
 
-	cfg, err := config.Load(configPath)
-	if err != nil {
+	cfg, err := loadConfig(flags)
+	if os.IsNotExist(err) {
 		log.Printf("Warning: configuration not found. Please run 'poe' to initialize Poe.")
-		// We still try to load defaults if config file is missing but Load handles that.
-		// However, we should probably fail if we want to enforce onboarding.
+		// Run on the defaults; the LLM client below fails without credentials.
+	} else if err != nil {
+		log.Fatal(err)
 	}
 
-	// Ensure .poe directory exists
-	if err := os.MkdirAll(os.ExpandEnv("$HOME/.poe"), 0755); err != nil {
+	// Ensure the database directory exists
+	if err := os.MkdirAll(filepath.Dir(cfg.Memory.DBPath), 0755); err != nil {
 		log.Fatalf("mkdir: %v", err)
 	}
 
-	mem, err := memory.Open(cfg.Memory.DBPath)
+	opts := memory.Options{VecExtension: cfg.Memory.VecExtension, MinSimilarity: cfg.Memory.MinSimilarity, Cipher: cfg.Cipher()}
+	if cfg.Memory.EmbeddingModel != "" {
+		emb, err := ai.NewEmbedder(cfg.Memory.EmbeddingModel, cfg.LLM.Auth)
+		if err != nil {
+			log.Printf("Warning: semantic search disabled: %v", err)
+		} else {
+			opts.Embedder = emb
+		}
+	}
+	mem, err := memory.OpenWith(cfg.Memory.DBPath, opts)
 	if err != nil {
 		log.Fatalf("memory: %v", err)
 	}
 	defer mem.Close()
 
+	llm, err := ai.NewClient(cfg.LLM)
+	if err != nil {
+		log.Fatalf("llm: %v (run 'poe configure' to fix)", err)
+	}
+	log.Printf("Using %s model %s", llm.Name(), cfg.LLM.Model)
+
+	sm := soul.New(home)
+	if err := sm.Init(); err != nil {
+		log.Fatalf("soul: %v", err)
+	}
+
 	age := agent.New(mem)
-	gtw := gateway.New(cfg, mem, age)
+	gtw := gateway.New(cfg, mem, age, llm, sm)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Reload on SIGHUP and when the config or soul files change, e.g. after
+	// poe configure.
+	reload := make(chan struct{}, 1)
+	go gateway.WatchFiles(ctx, 2*time.Second, reload, append([]string{configPath}, sm.Paths()...)...)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			case <-reload:
+			}
+			reloadConfig(flags, gtw)
+		}
+	}()
+
+	// Memories are embedded in the background as they are written; catch up
+	// on those written while the embedding server was slow or down.
+	go func() {
+		ticker := time.NewTicker(embedInterval)
+		defer ticker.Stop()
+		for {
+			n, err := mem.EmbedMissing(ctx)
+			if err != nil {
+				log.Printf("Warning: embedding memories: %v", err)
+			} else if n > 0 {
+				log.Printf("Embedded %d memories", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
 	log.Println("Poe AI Sidekick — starting daemon")
 	if err := gtw.Run(ctx); err != nil {
 		log.Fatalf("gateway: %v", err)
 	}
 }
+
+// loadConfig builds the effective configuration, validates it and unlocks
+// its credentials. A missing config file is returned as an error satisfying
+// os.IsNotExist along with the configuration from the other layers.
+func loadConfig(flags config.Flags) (config.Config, error) {
+	cfg, err := flags.Load()
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+	fileErr := err
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("%v\n(run 'poe configure' or edit %s to fix)", err, flags.Path)
+	}
+	if cfg.Encryption.Enabled() {
+		secret, err := cfg.Encryption.Secret()
+		if err != nil {
+			return cfg, fmt.Errorf("encryption: %w", err)
+		}
+		if err := cfg.Unlock(secret); err != nil {
+			return cfg, fmt.Errorf("encryption: %w", err)
+		}
+	}
+	return cfg, fileErr
+}
+
+// reloadConfig applies the configuration as it is now to the running
+// gateway. If it cannot be loaded, the gateway keeps the one it has.
+func reloadConfig(flags config.Flags, gtw *gateway.Gateway) {
+	// Run cmd: references again, for credentials rotated since they were read.
+	config.ForgetCachedSecrets()
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		log.Printf("Reload failed, keeping the running configuration: %v", err)
+		return
+	}
+	llm, err := ai.NewClient(cfg.LLM)
+	if err != nil {
+		log.Printf("Reload failed, keeping the running configuration: llm: %v", err)
+		return
+	}
+	restart := gtw.Reload(cfg, llm)
+	log.Printf("Reloaded configuration; using %s model %s", llm.Name(), cfg.LLM.Model)
+	if len(restart) > 0 {
+		log.Printf("Restart the gateway to apply changes to: %s", strings.Join(restart, ", "))
+	}
+}
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
+	"github.com/fjrt/poeai/internal/config"
 	"github.com/fjrt/poeai/internal/node"
 )
 
 func main() {
+	var flags config.Flags
+	flags.Register(flag.CommandLine)
+	flag.Parse()
+
+	// A phone may run poe-node without a config file; defaults apply.
+	cfg, err := flags.Load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("config: %v", err)
+	}
+
 	srv := node.New()
-	log.Println("Poe Node starting on :7332")
-	if err := srv.ListenAndServe(":7332"); err != nil {
+	log.Printf("Poe Node starting on %s", cfg.Node.Listen)
+	if err := srv.ListenAndServe(cfg.Node.Listen); err != nil {
 		log.Fatalf("node server: %v", err)
 	}
 }
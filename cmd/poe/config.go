@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// runConfig implements poe config show: the effective configuration after
+// the file, environment and flag layers, with credentials redacted and the
+// origin of each value.
+func runConfig(args []string, cfg config.Config) error {
+	if len(args) != 1 || args[0] != "show" {
+		return errors.New("usage: poe [--config file] [--set key=value] config show")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, s := range cfg.Redacted().Settings() {
+		fmt.Fprintf(w, "%s = %s\t# %s\n", s.Key, s.Value, s.Origin)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return nil
+}
@@ -1,31 +1,86 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"time"
 
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/crypt"
 	"github.com/fjrt/poeai/internal/onboarding"
+	"github.com/fjrt/poeai/internal/protocol"
 	"github.com/fjrt/poeai/internal/tui"
 	"github.com/gorilla/websocket"
 )
 
+// gatewayHost is the host:port of the gateway, from the configuration.
+var gatewayHost string
+
 func main() {
+	var flags config.Flags
+	flags.Register(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: poe [flags] [sessions | resume <id> | shell <node> | configure | config show | stack | rekey]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	configPath := flags.Path
+
+	cfg, err := flags.Load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("config: %v", err)
+	}
+	gatewayHost = cfg.Gateway.Addr()
 	home, _ := os.UserHomeDir()
-	configPath := filepath.Join(home, ".poe", "config.toml")
 
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
+	var sessionID string
+	if len(args) > 0 {
+		switch args[0] {
+		case "sessions":
+			if err := listSessions(cfg); err != nil {
+				log.Fatalf("sessions: %v", err)
+			}
+			return
+		case "config":
+			if err := runConfig(args[1:], cfg); err != nil {
+				log.Fatalf("config: %v", err)
+			}
+			return
+		case "shell":
+			if err := runShell(args[1:], cfg); err != nil {
+				log.Fatalf("shell: %v", err)
+			}
+			return
+		case "stack":
+			if err := runStack(args[1:], home, configPath, cfg); err != nil {
+				log.Fatalf("stack: %v", err)
+			}
+			return
+		case "rekey":
+			if err := runRekey(args[1:], configPath, cfg); err != nil {
+				log.Fatalf("rekey: %v", err)
+			}
+			return
+		case "resume":
+			if len(args) < 2 {
+				log.Fatalf("usage: poe resume <session-id>  (see 'poe sessions')")
+			}
+			sessionID = args[1]
 		case "configure":
-			_, err := onboarding.Configure()
+			_, err := onboarding.Configure(configPath)
 			if err != nil {
 				log.Fatalf("Configure failed: %v", err)
 			}
 			return
 		case "onboarding":
-			_, err := onboarding.Onboard()
+			_, err := onboarding.Onboard(configPath)
 			if err != nil {
 				log.Fatalf("Onboarding failed: %v", err)
 			}
@@ -35,12 +90,12 @@ func main() {
 	}
 
 	// Try to dial gateway
-	u := url.URL{Scheme: "ws", Host: "localhost:7331", Path: "/ws"}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	u := url.URL{Scheme: "ws", Host: gatewayHost, Path: "/ws"}
+	ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
 		// Gateway is down. Check if we need onboarding.
 		if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
-			_, err := onboarding.Onboard()
+			_, err := onboarding.Onboard(configPath)
 			if err != nil {
 				log.Fatalf("Onboarding failed: %v", err)
 			}
@@ -49,9 +104,81 @@ func main() {
 		}
 		log.Fatalf("Poe Gateway is not running. Please start it with: poe-gateway (Error: %v)", err)
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
 
-	if err := tui.Run(conn); err != nil {
+	token, err := gatewayToken(cfg)
+	if err != nil {
+		log.Fatalf("gateway token: %v", err)
+	}
+	welcome, err := protocol.Handshake(conn, protocol.Hello{Client: "poe-tui", Session: sessionID, Token: token})
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.Code == protocol.CodeSessionNotFound {
+		log.Fatalf("Session %s not found. List sessions with: poe sessions", sessionID)
+	}
+	if err != nil {
+		log.Fatalf("handshake: %v", err)
+	}
+
+	if err := tui.Run(conn, welcome, cfg.Nodes); err != nil {
 		log.Fatalf("tui: %v", err)
 	}
 }
+
+// gatewayToken returns the token to send to a gateway on another machine;
+// one on this machine needs none.
+func gatewayToken(cfg config.Config) (string, error) {
+	if cfg.Gateway.Token == "" || config.IsLoopback(cfg.Gateway.Host) {
+		return "", nil
+	}
+	if crypt.IsSealed(cfg.Gateway.Token) {
+		if err := onboarding.Unlock(&cfg); err != nil {
+			return "", err
+		}
+	}
+	return config.ResolveSecret(cfg.Gateway.Token)
+}
+
+// listSessions prints the gateway's recent sessions for use with poe resume.
+func listSessions(cfg config.Config) error {
+	token, err := gatewayToken(cfg)
+	if err != nil {
+		return fmt.Errorf("gateway token: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+gatewayHost+"/sessions", nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Poe Gateway is not running: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gateway returned %s", resp.Status)
+	}
+
+	var sessions []struct {
+		ID        string    `json:"id"`
+		Title     string    `json:"title"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions yet.")
+		return nil
+	}
+	for _, s := range sessions {
+		title := s.Title
+		if title == "" {
+			title = "(empty)"
+		}
+		fmt.Printf("%s  %s  %s\n", s.ID, s.UpdatedAt.Format("2006-01-02 15:04"), title)
+	}
+	return nil
+}
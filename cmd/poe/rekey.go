@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/crypt"
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/onboarding"
+)
+
+// runRekey encrypts the memory database and the credentials in the config
+// with a new passphrase or key file, turning encryption on if it was off, or
+// decrypts them with --decrypt. The key is saved to the file at configPath;
+// effective is the configuration with overrides, which locates the database.
+func runRekey(args []string, configPath string, effective config.Config) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	keyFile := fs.String("keyfile", "", "derive the key from this file, creating it if missing, instead of a passphrase")
+	decrypt := fs.Bool("decrypt", false, "turn encryption off")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile != "" && *decrypt {
+		return errors.New("--keyfile and --decrypt are mutually exclusive")
+	}
+	if *keyFile != "" {
+		abs, err := filepath.Abs(*keyFile)
+		if err != nil {
+			return err
+		}
+		*keyFile = abs
+	}
+
+	// Rewriting the database under a running gateway would lose its writes.
+	client := http.Client{Timeout: time.Second}
+	if resp, err := client.Get("http://" + gatewayHost + "/sessions"); err == nil {
+		resp.Body.Close()
+		return errors.New("Poe Gateway is running; stop it first")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := onboarding.Unlock(&cfg); err != nil {
+		return err
+	}
+	store, err := memory.OpenWith(effective.Memory.DBPath, memory.Options{Cipher: cfg.Cipher()})
+	if err != nil {
+		return fmt.Errorf("memory: %w", err)
+	}
+	defer store.Close()
+
+	var cipher *crypt.Cipher
+	var params crypt.Params
+	if !*decrypt {
+		var secret []byte
+		if *keyFile != "" {
+			if err := crypt.NewKeyFile(*keyFile); err == nil {
+				fmt.Printf("Created key file %s; keep a copy somewhere safe.\n", *keyFile)
+			} else if !os.IsExist(err) {
+				return err
+			}
+			secret, err = config.EncryptionConfig{KeyFile: *keyFile}.Secret()
+		} else {
+			var passphrase string
+			passphrase, err = onboarding.NewPassphrase()
+			secret = []byte(passphrase)
+		}
+		if err != nil {
+			return err
+		}
+		if cipher, params, err = crypt.NewKey(secret); err != nil {
+			return err
+		}
+	}
+
+	// Write the new config aside first, so that a failure leaves both the
+	// database and the config under the old key.
+	cfg.SetKey(cipher, params, *keyFile)
+	pending := configPath + ".rekey"
+	if err := cfg.Save(pending); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+	if err := store.Rekey(context.Background(), cipher); err != nil {
+		os.Remove(pending)
+		return err
+	}
+	if err := os.Rename(pending, configPath); err != nil {
+		return fmt.Errorf("memories were rekeyed but the config was not saved; %s holds the new key: %w", pending, err)
+	}
+
+	if cipher == nil {
+		fmt.Println("Encryption is off. Memories, sessions and credentials are stored in plaintext.")
+	} else {
+		fmt.Println("Memories, sessions and credentials are encrypted with the new key.")
+		fmt.Println("Facts and embeddings are not encrypted.")
+	}
+	return nil
+}
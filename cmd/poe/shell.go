@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/crypt"
+	"github.com/fjrt/poeai/internal/onboarding"
+	"github.com/fjrt/poeai/internal/ssh"
+	"github.com/fjrt/poeai/internal/tui"
+)
+
+// runShell implements poe shell <node>: an interactive shell on a configured
+// node, the same as /shell in the chat.
+func runShell(args []string, cfg config.Config) error {
+	if len(args) != 1 {
+		return errors.New("usage: poe shell <node>")
+	}
+	n, ok := cfg.Nodes[args[0]]
+	if !ok {
+		names := make([]string, 0, len(cfg.Nodes))
+		for name := range cfg.Nodes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no node %q in the configuration (configured: %s)", args[0], strings.Join(names, ", "))
+	}
+	// The node's key passphrase may be encrypted with the rest of the config.
+	if crypt.IsSealed(n.Passphrase) {
+		if err := onboarding.Unlock(&cfg); err != nil {
+			return err
+		}
+		n = cfg.Nodes[args[0]]
+	}
+
+	client := ssh.NewNode(n, ssh.Options{Passphrase: onboarding.KeyPassphrase})
+	defer client.Close()
+	return tui.NewShell(client).Run()
+}
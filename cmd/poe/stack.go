@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/onboarding"
+	"github.com/fjrt/poeai/internal/stack"
+)
+
+const stackUsage = `usage: poe stack <command> [file]
+
+  backup [file]   archive the database, SOUL.md, AGENTS.md and config.toml
+                  (default file: poe-stack-<time>.tar.gz, "-" for stdout)
+  restore <file>  replace the installation with an archive; stop the gateway first
+  export [file]   write memories and facts as JSON lines (default: stdout)
+  import <file>   add memories and facts from an export ("-" for stdin)`
+
+// runStack implements the poe stack subcommands.
+func runStack(args []string, home, configPath string, cfg config.Config) error {
+	if len(args) == 0 {
+		return errors.New(stackUsage)
+	}
+	if args[0] == "export" || args[0] == "import" {
+		if err := onboarding.Unlock(&cfg); err != nil {
+			return err
+		}
+	}
+	paths := stack.DefaultPaths(home, cfg.Memory.DBPath)
+	paths.Config = configPath
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	file := ""
+	if len(args) > 1 {
+		file = args[1]
+	}
+	switch args[0] {
+	case "backup":
+		if file == "" {
+			file = "poe-stack-" + time.Now().Format("20060102-150405") + ".tar.gz"
+		}
+		return createFile(file, func(w io.Writer) error {
+			m, err := stack.Backup(ctx, paths, w)
+			if err == nil && file != "-" {
+				fmt.Fprintf(os.Stderr, "Backed up %d files (schema version %d) to %s\n", len(m.Files), m.SchemaVersion, file)
+			}
+			return err
+		})
+
+	case "restore":
+		if file == "" {
+			return errors.New(stackUsage)
+		}
+		r, err := openFile(file)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		m, err := stack.Restore(ctx, r, paths)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d files from the backup of %s; replaced files were kept as *.bak\n",
+			len(m.Files), m.CreatedAt.Local().Format("2006-01-02 15:04"))
+		return nil
+
+	case "export":
+		if file == "" {
+			file = "-"
+		}
+		store, err := memory.OpenWith(cfg.Memory.DBPath, memory.Options{Cipher: cfg.Cipher()})
+		if err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+		defer store.Close()
+		return createFile(file, func(w io.Writer) error {
+			n, err := store.Export(ctx, w)
+			if err == nil && file != "-" {
+				fmt.Fprintf(os.Stderr, "Exported %d records to %s\n", n, file)
+			}
+			return err
+		})
+
+	case "import":
+		if file == "" {
+			return errors.New(stackUsage)
+		}
+		r, err := openFile(file)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		opts := memory.Options{VecExtension: cfg.Memory.VecExtension, Cipher: cfg.Cipher()}
+		if cfg.Memory.EmbeddingModel != "" {
+			// Without an embedder, the gateway embeds the imported memories
+			// when it next starts.
+			if emb, err := ai.NewEmbedder(cfg.Memory.EmbeddingModel, cfg.LLM.Auth); err == nil {
+				opts.Embedder = emb
+			}
+		}
+		store, err := memory.OpenWith(cfg.Memory.DBPath, opts)
+		if err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+		defer store.Close()
+		res, err := store.Import(ctx, r)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d memories and %d facts; skipped %d records already present\n",
+			res.Memories, res.Facts, res.Skipped)
+		return nil
+	}
+	return errors.New(stackUsage)
+}
+
+// createFile calls write with the named file, or stdout for "-". The file is
+// private since archives hold credentials, and is removed if write fails.
+func createFile(name string, write func(io.Writer) error) error {
+	if name == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
+
+// openFile opens the named file, or stdin for "-".
+func openFile(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
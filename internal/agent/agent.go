@@ -3,34 +3,85 @@ package agent
 import (
 	"context"
 	"fmt"
+	"sort"
 
+	"github.com/fjrt/poeai/internal/ai"
 	"github.com/fjrt/poeai/internal/memory"
 )
 
 type ToolFunc func(ctx context.Context, params map[string]interface{}) (string, error)
 
+// Tool is a registered tool. Parameters is the JSON schema of params that is
+// declared to the model.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+	Func        ToolFunc
+}
+
 type Agent struct {
-	tools  map[string]ToolFunc
+	tools  map[string]Tool
 	memory *memory.Store
+
+	// MaxIterations caps the model round trips Run makes for one turn.
+	MaxIterations int
 }
 
 func New(m *memory.Store) *Agent {
 	a := &Agent{
-		tools:  make(map[string]ToolFunc),
-		memory: m,
+		tools:         make(map[string]Tool),
+		memory:        m,
+		MaxIterations: 8,
 	}
 	a.registerCoreTools()
 	return a
 }
 
+// Register adds a tool, replacing any tool with the same name.
+func (a *Agent) Register(t Tool) {
+	if t.Parameters == nil {
+		t.Parameters = object(nil)
+	}
+	a.tools[t.Name] = t
+}
+
+// RegisterTool adds a tool that takes no declared parameters.
 func (a *Agent) RegisterTool(name string, fn ToolFunc) {
-	a.tools[name] = fn
+	a.Register(Tool{Name: name, Func: fn})
+}
+
+// Tools returns the declarations of all registered tools, sorted by name.
+func (a *Agent) Tools() []ai.Tool {
+	out := make([]ai.Tool, 0, len(a.tools))
+	for _, t := range a.tools {
+		out = append(out, ai.Tool{Name: t.Name, Description: t.Description, Parameters: t.Parameters})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
 }
 
 func (a *Agent) Dispatch(ctx context.Context, name string, params map[string]interface{}) (string, error) {
-	fn, ok := a.tools[name]
+	t, ok := a.tools[name]
 	if !ok {
 		return "", fmt.Errorf("tool %q not found", name)
 	}
-	return fn(ctx, params)
+	return t.Func(ctx, params)
+}
+
+// object builds a JSON schema for an arguments object.
+func object(props map[string]interface{}, required ...string) map[string]interface{} {
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// prop builds a JSON schema for a single scalar property.
+func prop(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
 }
@@ -2,9 +2,12 @@ package agent_test
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/ai"
 	"github.com/fjrt/poeai/internal/memory"
 )
 
@@ -40,3 +43,114 @@ func TestAgent_ToolDispatch(t *testing.T) {
 		t.Error("Search should have found the memory we just wrote")
 	}
 }
+
+func TestAgent_MemoryAndFactTools(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+	a := agent.New(mem)
+	ctx := context.Background()
+
+	id, _ := mem.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "ha-server runs Debian 11"})
+	if _, err := a.Dispatch(ctx, "memory_update", map[string]interface{}{"id": id, "content": "ha-server runs Debian 12"}); err != nil {
+		t.Fatalf("memory_update error = %v", err)
+	}
+	if m, _, _ := mem.Get(ctx, id); m.Content != "ha-server runs Debian 12" {
+		t.Errorf("content after memory_update = %q", m.Content)
+	}
+	if _, err := a.Dispatch(ctx, "memory_delete", map[string]interface{}{"id": id}); err != nil {
+		t.Fatalf("memory_delete error = %v", err)
+	}
+	if _, err := a.Dispatch(ctx, "memory_delete", map[string]interface{}{"id": id}); err == nil {
+		t.Error("memory_delete of a deleted memory succeeded")
+	}
+
+	if _, err := a.Dispatch(ctx, "fact_set", map[string]interface{}{"key": "homelab.ha-server.os", "value": "Debian 12"}); err != nil {
+		t.Fatalf("fact_set error = %v", err)
+	}
+	got, err := a.Dispatch(ctx, "fact_get", map[string]interface{}{"key": "homelab.ha-server.os"})
+	if err != nil || !strings.Contains(got, "Debian 12") {
+		t.Errorf("fact_get = %q, %v", got, err)
+	}
+}
+
+// scriptedLLM replays one canned reply per Stream call and records requests.
+type scriptedLLM struct {
+	replies  []ai.Message
+	requests []ai.Request
+}
+
+func (s *scriptedLLM) Name() string { return "scripted" }
+
+func (s *scriptedLLM) Completion(ctx context.Context, messages []ai.Message) (string, error) {
+	resp, err := s.Chat(ctx, ai.Request{Messages: messages})
+	return resp.Message.Content, err
+}
+
+func (s *scriptedLLM) Chat(ctx context.Context, req ai.Request) (ai.Response, error) {
+	events, _ := s.Stream(ctx, req)
+	return ai.CollectResponse(events)
+}
+
+func (s *scriptedLLM) Stream(ctx context.Context, req ai.Request) (<-chan ai.StreamEvent, error) {
+	s.requests = append(s.requests, req)
+	reply := s.replies[0]
+	if len(s.replies) > 1 {
+		s.replies = s.replies[1:]
+	}
+	ch := make(chan ai.StreamEvent, len(reply.ToolCalls)+2)
+	if reply.Content != "" {
+		ch <- ai.StreamEvent{Delta: reply.Content}
+	}
+	for i := range reply.ToolCalls {
+		ch <- ai.StreamEvent{ToolCall: &reply.ToolCalls[i]}
+	}
+	ch <- ai.StreamEvent{Done: true}
+	close(ch)
+	return ch, nil
+}
+
+func TestAgent_Run(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+	a := agent.New(mem)
+
+	llm := &scriptedLLM{replies: []ai.Message{
+		{ToolCalls: []ai.ToolCall{{ID: "call_1", Name: "memory_write", Arguments: map[string]interface{}{"content": "fjrt prefers ripgrep"}}}},
+		{Content: "Noted."},
+	}}
+	var events []agent.EventType
+	added, err := a.Run(context.Background(), llm, []ai.Message{{Role: ai.RoleUser, Content: "I prefer ripgrep"}},
+		func(ev agent.Event) { events = append(events, ev.Type) })
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(added) != 3 || added[1].Role != ai.RoleTool || added[1].ToolCallID != "call_1" || added[2].Content != "Noted." {
+		t.Fatalf("Run() added = %+v", added)
+	}
+	if !strings.HasPrefix(added[1].Content, "Memory stored") {
+		t.Errorf("tool result = %q", added[1].Content)
+	}
+	if len(llm.requests) != 2 || len(llm.requests[0].Tools) == 0 || len(llm.requests[1].Messages) != 3 {
+		t.Errorf("requests = %+v", llm.requests)
+	}
+	want := []agent.EventType{agent.EventToolCall, agent.EventToolResult, agent.EventDelta}
+	if len(events) != len(want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestAgent_RunIterationLimit(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+	a := agent.New(mem)
+	a.MaxIterations = 2
+
+	llm := &scriptedLLM{replies: []ai.Message{
+		{ToolCalls: []ai.ToolCall{{ID: "call_1", Name: "memory_search", Arguments: map[string]interface{}{"query": "x"}}}},
+	}}
+	_, err := a.Run(context.Background(), llm, []ai.Message{{Role: ai.RoleUser, Content: "loop"}}, nil)
+	if !errors.Is(err, agent.ErrIterationLimit) {
+		t.Errorf("Run() error = %v, want ErrIterationLimit", err)
+	}
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/fjrt/poeai/internal/ai"
+)
+
+// ErrIterationLimit is returned by Run when the model keeps calling tools
+// past MaxIterations without giving a final answer.
+var ErrIterationLimit = errors.New("agent: iteration limit reached")
+
+// EventType identifies what an Event reports.
+type EventType int
+
+const (
+	EventDelta      EventType = iota // streamed reply text in Delta
+	EventToolCall                    // ToolCall is about to run
+	EventToolResult                  // ToolCall ran; see Result and Err
+)
+
+// Event reports progress while Run works on a turn.
+type Event struct {
+	Type     EventType
+	Delta    string
+	ToolCall ai.ToolCall
+	Result   string
+	Err      error
+}
+
+// Run drives one conversational turn: it streams history to llm with the
+// registered tools declared, dispatches any tool calls the model makes, feeds
+// the results back and repeats until the model answers without calling a
+// tool. Progress is reported to onEvent, which may be nil.
+//
+// Run returns the messages it added to the conversation (assistant turns and
+// tool results, ending with the final answer) so the caller can keep them.
+func (a *Agent) Run(ctx context.Context, llm ai.Client, history []ai.Message, onEvent func(Event)) ([]ai.Message, error) {
+	if onEvent == nil {
+		onEvent = func(Event) {}
+	}
+	tools := a.Tools()
+	messages := append([]ai.Message(nil), history...)
+	var added []ai.Message
+
+	for i := 0; i < a.MaxIterations; i++ {
+		events, err := llm.Stream(ctx, ai.Request{Messages: messages, Tools: tools})
+		if err != nil {
+			return added, err
+		}
+		reply := ai.Message{Role: ai.RoleAssistant}
+		for ev := range events {
+			switch {
+			case ev.Err != nil:
+				err = ev.Err
+			case ev.ToolCall != nil:
+				reply.ToolCalls = append(reply.ToolCalls, *ev.ToolCall)
+			case ev.Delta != "":
+				reply.Content += ev.Delta
+				onEvent(Event{Type: EventDelta, Delta: ev.Delta})
+			}
+		}
+		if err != nil {
+			return added, err
+		}
+
+		messages = append(messages, reply)
+		added = append(added, reply)
+		if len(reply.ToolCalls) == 0 {
+			return added, nil
+		}
+
+		for _, call := range reply.ToolCalls {
+			onEvent(Event{Type: EventToolCall, ToolCall: call})
+			result, err := a.Dispatch(ctx, call.Name, call.Arguments)
+			if err != nil {
+				log.Printf("tool %s: %v", call.Name, err)
+				result = fmt.Sprintf("error: %v", err)
+			}
+			onEvent(Event{Type: EventToolResult, ToolCall: call, Result: result, Err: err})
+
+			msg := ai.Message{Role: ai.RoleTool, ToolCallID: call.ID, Content: result}
+			messages = append(messages, msg)
+			added = append(added, msg)
+		}
+	}
+	return added, ErrIterationLimit
+}
@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/ssh"
+)
+
+// NodeRunner gives the node tools the configured nodes and runs commands on
+// them. The gateway implements it with pooled SSH connections.
+type NodeRunner interface {
+	Nodes() map[string]config.NodeConfig
+	Exec(ctx context.Context, node, cmd string) (ssh.Result, error)
+}
+
+// Limits of the node tools, to keep results within the model's context.
+const (
+	nodeOutputLimit    = 16 << 10 // bytes of stdout and of stderr returned by node_exec
+	nodeFileLimit      = 64 << 10 // bytes returned by node_file_read
+	nodeExecTimeout    = time.Minute
+	nodeExecMaxTimeout = 10 * time.Minute
+)
+
+// RegisterNodeTools adds tools to inspect and run commands on the homelab
+// nodes of r, within each node's allow and deny policy.
+func (a *Agent) RegisterNodeTools(r NodeRunner) {
+	a.Register(Tool{
+		Name:        "node_list",
+		Description: "List the homelab nodes Poe can reach over SSH, with the commands each allows.",
+		Func: func(ctx context.Context, params map[string]interface{}) (string, error) {
+			return nodeList(r.Nodes()), nil
+		},
+	})
+	a.Register(Tool{
+		Name:        "node_exec",
+		Description: "Run a shell command on a homelab node and return its exit code and output. Commands must be allowed by the node's policy.",
+		Parameters: object(map[string]interface{}{
+			"node":            prop("string", "Name of the node, as listed by node_list."),
+			"command":         prop("string", "The command line to run."),
+			"timeout_seconds": prop("integer", "Seconds after which the command is killed. Defaults to 60, at most 600."),
+		}, "node", "command"),
+		Func: func(ctx context.Context, params map[string]interface{}) (string, error) {
+			return nodeExec(ctx, r, params)
+		},
+	})
+	a.Register(Tool{
+		Name:        "node_status",
+		Description: "Report a node's uptime, load averages, disk usage per filesystem and memory usage, as JSON. Allowed on every node.",
+		Parameters: object(map[string]interface{}{
+			"node": prop("string", "Name of the node, as listed by node_list."),
+		}, "node"),
+		Func: func(ctx context.Context, params map[string]interface{}) (string, error) {
+			return nodeStatus(ctx, r, params)
+		},
+	})
+	a.Register(Tool{
+		Name:        "node_file_read",
+		Description: "Read a text file on a homelab node, up to 64 KiB.",
+		Parameters: object(map[string]interface{}{
+			"node": prop("string", "Name of the node, as listed by node_list."),
+			"path": prop("string", "Absolute path of the file."),
+		}, "node", "path"),
+		Func: func(ctx context.Context, params map[string]interface{}) (string, error) {
+			return nodeFileRead(ctx, r, params)
+		},
+	})
+}
+
+func nodeList(nodes map[string]config.NodeConfig) string {
+	if len(nodes) == 0 {
+		return "No nodes are configured."
+	}
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		n := nodes[name]
+		fmt.Fprintf(&sb, "- %s: %s@%s", name, n.User, n.Host)
+		if len(n.Allow) > 0 {
+			fmt.Fprintf(&sb, "; allowed: %s", strings.Join(n.Allow, ", "))
+		}
+		if len(n.Deny) > 0 {
+			fmt.Fprintf(&sb, "; denied: %s", strings.Join(n.Deny, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// node looks up the node named by params["node"].
+func node(r NodeRunner, params map[string]interface{}) (string, config.NodeConfig, error) {
+	name, _ := params["node"].(string)
+	if name == "" {
+		return "", config.NodeConfig{}, fmt.Errorf("missing node")
+	}
+	n, ok := r.Nodes()[name]
+	if !ok {
+		return "", config.NodeConfig{}, fmt.Errorf("no node %q; see node_list", name)
+	}
+	return name, n, nil
+}
+
+func nodeExec(ctx context.Context, r NodeRunner, params map[string]interface{}) (string, error) {
+	name, n, err := node(r, params)
+	if err != nil {
+		return "", err
+	}
+	cmd, _ := params["command"].(string)
+	if strings.TrimSpace(cmd) == "" {
+		return "", fmt.Errorf("missing command")
+	}
+	if err := permitted(n, cmd); err != nil {
+		return "", fmt.Errorf("node %s: %w", name, err)
+	}
+	timeout := nodeExecTimeout
+	if s, ok := params["timeout_seconds"].(float64); ok && s > 0 {
+		timeout = min(time.Duration(s*float64(time.Second)), nodeExecMaxTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res, err := r.Exec(ctx, name, cmd)
+	if err != nil {
+		return "", fmt.Errorf("node %s: %w", name, err)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "exit code %d\n", res.ExitCode)
+	if res.Stdout != "" {
+		sb.WriteString("stdout:\n" + truncate(res.Stdout, nodeOutputLimit))
+	}
+	if res.Stderr != "" {
+		sb.WriteString("stderr:\n" + truncate(res.Stderr, nodeOutputLimit))
+	}
+	return sb.String(), nil
+}
+
+func nodeFileRead(ctx context.Context, r NodeRunner, params map[string]interface{}) (string, error) {
+	name, n, err := node(r, params)
+	if err != nil {
+		return "", err
+	}
+	p, _ := params["path"].(string)
+	if p == "" {
+		return "", fmt.Errorf("missing path")
+	}
+	if !path.IsAbs(p) {
+		return "", fmt.Errorf("path %q is not absolute", p)
+	}
+	// The policy is checked on the path the shell will open, so that
+	// /etc/../root is not read as if it were under /etc.
+	p = path.Clean(p)
+	if err := permitted(n, "cat "+shellQuote(p)); err != nil {
+		return "", fmt.Errorf("node %s: %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nodeExecTimeout)
+	defer cancel()
+	// One byte more than the limit tells whether the file was cut off.
+	res, err := r.Exec(ctx, name, fmt.Sprintf("head -c %d -- %s", nodeFileLimit+1, shellQuote(p)))
+	if err != nil {
+		return "", fmt.Errorf("node %s: %w", name, err)
+	}
+	if res.ExitCode != 0 {
+		return "", fmt.Errorf("node %s: read %s: %s", name, p, strings.TrimSpace(res.Stderr))
+	}
+	return truncate(res.Stdout, nodeFileLimit), nil
+}
+
+// truncate cuts s to limit bytes, saying so.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + fmt.Sprintf("\n[truncated to %d bytes]\n", limit)
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// statusCommand prints what NodeStatus holds using standard Linux tools, in
+// sections separated by a marker line. It only reads and is run whatever the
+// node's policy, so that every node can report its status.
+const statusCommand = "cat /proc/uptime; echo " + statusMarker + "; cat /proc/loadavg; echo " + statusMarker +
+	"; df -P -k -x tmpfs -x devtmpfs -x overlay -x squashfs 2>/dev/null; echo " + statusMarker + "; free -b"
+
+const statusMarker = "--poe-status--"
+
+// NodeStatus is the structured result of node_status.
+type NodeStatus struct {
+	Node          string      `json:"node"`
+	UptimeSeconds float64     `json:"uptime_seconds"`
+	Load          [3]float64  `json:"load"` // 1, 5 and 15 minute averages
+	Disks         []DiskUsage `json:"disks"`
+	Memory        MemoryUsage `json:"memory"`
+}
+
+// DiskUsage is the usage of one mounted filesystem.
+type DiskUsage struct {
+	Filesystem  string  `json:"filesystem"`
+	Mount       string  `json:"mount"`
+	SizeBytes   uint64  `json:"size_bytes"`
+	UsedBytes   uint64  `json:"used_bytes"`
+	AvailBytes  uint64  `json:"available_bytes"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// MemoryUsage is the memory and swap usage of a node.
+type MemoryUsage struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	UsedBytes      uint64 `json:"used_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+	SwapTotalBytes uint64 `json:"swap_total_bytes"`
+	SwapUsedBytes  uint64 `json:"swap_used_bytes"`
+}
+
+func nodeStatus(ctx context.Context, r NodeRunner, params map[string]interface{}) (string, error) {
+	name, _, err := node(r, params)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, nodeExecTimeout)
+	defer cancel()
+	res, err := r.Exec(ctx, name, statusCommand)
+	if err != nil {
+		return "", fmt.Errorf("node %s: %w", name, err)
+	}
+	st, err := parseStatus(res.Stdout)
+	if err != nil {
+		return "", fmt.Errorf("node %s: status: %w", name, err)
+	}
+	st.Node = name
+	out, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// parseStatus parses the output of the node_status command: /proc/uptime,
+// /proc/loadavg, df -P -k and free -b.
+func parseStatus(out string) (NodeStatus, error) {
+	var st NodeStatus
+	sections := strings.Split(out, statusMarker+"\n")
+	if len(sections) != 4 {
+		return st, fmt.Errorf("unexpected output %q", truncate(out, 200))
+	}
+
+	if f := strings.Fields(sections[0]); len(f) > 0 {
+		st.UptimeSeconds, _ = strconv.ParseFloat(f[0], 64)
+	}
+	f := strings.Fields(sections[1])
+	for i := 0; i < 3 && i < len(f); i++ {
+		st.Load[i], _ = strconv.ParseFloat(f[i], 64)
+	}
+
+	// Filesystem 1024-blocks Used Available Capacity Mounted on
+	for _, line := range strings.Split(sections[2], "\n")[1:] {
+		f := strings.Fields(line)
+		if len(f) < 6 {
+			continue
+		}
+		size, _ := strconv.ParseUint(f[1], 10, 64)
+		used, _ := strconv.ParseUint(f[2], 10, 64)
+		avail, _ := strconv.ParseUint(f[3], 10, 64)
+		pct, _ := strconv.ParseFloat(strings.TrimSuffix(f[4], "%"), 64)
+		st.Disks = append(st.Disks, DiskUsage{
+			Filesystem:  f[0],
+			Mount:       strings.Join(f[5:], " "),
+			SizeBytes:   size * 1024,
+			UsedBytes:   used * 1024,
+			AvailBytes:  avail * 1024,
+			UsedPercent: pct,
+		})
+	}
+
+	//                total        used        free      shared  buff/cache   available
+	// Mem:     16624361472  ...
+	// Swap:     2147479552  ...
+	for _, line := range strings.Split(sections[3], "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		n := func(i int) uint64 {
+			if i >= len(f) {
+				return 0
+			}
+			v, _ := strconv.ParseUint(f[i], 10, 64)
+			return v
+		}
+		switch f[0] {
+		case "Mem:":
+			st.Memory.TotalBytes, st.Memory.UsedBytes, st.Memory.AvailableBytes = n(1), n(2), n(6)
+		case "Swap:":
+			st.Memory.SwapTotalBytes, st.Memory.SwapUsedBytes = n(1), n(2)
+		}
+	}
+	return st, nil
+}
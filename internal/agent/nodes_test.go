@@ -0,0 +1,151 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/ssh"
+)
+
+// fakeNodes answers commands from a table and records what was run.
+type fakeNodes struct {
+	nodes   map[string]config.NodeConfig
+	replies map[string]ssh.Result
+	ran     []string
+}
+
+func (f *fakeNodes) Nodes() map[string]config.NodeConfig { return f.nodes }
+
+func (f *fakeNodes) Exec(ctx context.Context, node, cmd string) (ssh.Result, error) {
+	f.ran = append(f.ran, node+": "+cmd)
+	for prefix, res := range f.replies {
+		if strings.HasPrefix(cmd, prefix) {
+			return res, nil
+		}
+	}
+	return ssh.Result{Stdout: "ok\n"}, nil
+}
+
+const statusOutput = `12345.67 45678.90
+--poe-status--
+0.52 0.58 0.59 2/345 6789
+--poe-status--
+Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         10000000  4000000   6000000      40% /
+/dev/sdb1        200000000 50000000 150000000      25% /mnt/media files
+--poe-status--
+               total        used        free      shared  buff/cache   available
+Mem:     16000000000  6000000000  2000000000   100000000  8000000000 9500000000
+Swap:     2000000000   100000000  1900000000
+`
+
+func TestAgent_NodeTools(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+
+	nodes := &fakeNodes{
+		nodes: map[string]config.NodeConfig{
+			"nas": {Host: "nas.lan", User: "fjrt", Allow: []string{"systemctl status *", "df *", "cat /etc/*", "uptime"}, Deny: []string{"cat /etc/shadow"}},
+			"pi":  {Host: "pi.lan", User: "pi", Deny: []string{"rm *", "reboot*", "/sbin/shutdown"}},
+		},
+		replies: map[string]ssh.Result{
+			"cat /proc/uptime": {Stdout: statusOutput},
+			"head -c":          {Stdout: "nas.lan\n"},
+		},
+	}
+	a := agent.New(mem)
+	a.RegisterNodeTools(nodes)
+	ctx := context.Background()
+
+	res, err := a.Dispatch(ctx, "node_list", nil)
+	if err != nil || !strings.Contains(res, "- nas: fjrt@nas.lan") || !strings.Contains(res, "- pi: pi@pi.lan") {
+		t.Errorf("node_list = %q, %v", res, err)
+	}
+
+	policy := []struct {
+		node, cmd string
+		ok        bool
+	}{
+		{"nas", "uptime", true},
+		{"nas", "systemctl status nginx && df -h", true},
+		{"nas", "systemctl restart nginx", false},
+		{"nas", "uptime; rm -rf /", false},
+		{"nas", "uptime > /tmp/x", false},
+		{"nas", "df $(rm -rf /)", false},
+		{"pi", "sudo apt update | tail", true},
+		{"pi", "rm -rf /tmp/x", false},
+		{"pi", "ls; reboot", false},
+		{"pi", "reboot now", false},
+		{"pi", "echo $(reboot)", false},
+		{"pi", "echo `reboot`", false},
+		{"pi", "x=reboot; $x", false},
+		{"pi", "/sbin/reboot", false},
+		{"pi", "sudo -u root reboot", false},
+		{"pi", "sudo env FOO=1 nice -n 5 reboot", false},
+		{"pi", "sh -c 'reboot'", false},
+		{"pi", "bash -lc \"uptime; reboot\"", false},
+		{"pi", "eval reboot", false},
+		{"pi", "(reboot)", false},
+		{"pi", "if true; then shutdown -h now; fi", false},
+		{"pi", "re\\boot", false},
+		{"pi", "echo 'reboot is scheduled'", true},
+		{"nas", "cat /etc/hostname", true},
+		{"nas", "cat /etc/hostname /root/.ssh/id_ed25519", false},
+		{"nas", "cat /etc/../root/.ssh/id_ed25519", false},
+		{"nas", "cat /etc//shadow", false},
+		{"router", "uptime", false},
+	}
+	for _, tc := range policy {
+		nodes.ran = nil
+		_, err := a.Dispatch(ctx, "node_exec", map[string]interface{}{"node": tc.node, "command": tc.cmd})
+		if (err == nil) != tc.ok {
+			t.Errorf("node_exec %s %q: err = %v, want allowed %v", tc.node, tc.cmd, err, tc.ok)
+		}
+		if !tc.ok && len(nodes.ran) > 0 {
+			t.Errorf("node_exec %s %q ran %q although refused", tc.node, tc.cmd, nodes.ran)
+		}
+	}
+
+	res, err = a.Dispatch(ctx, "node_status", map[string]interface{}{"node": "nas"})
+	if err != nil {
+		t.Fatalf("node_status: %v", err)
+	}
+	var st agent.NodeStatus
+	if err := json.Unmarshal([]byte(res), &st); err != nil {
+		t.Fatalf("node_status result %q: %v", res, err)
+	}
+	if st.Node != "nas" || st.UptimeSeconds != 12345.67 || st.Load != [3]float64{0.52, 0.58, 0.59} {
+		t.Errorf("node_status = %+v", st)
+	}
+	want := []agent.DiskUsage{
+		{Filesystem: "/dev/sda1", Mount: "/", SizeBytes: 10240000000, UsedBytes: 4096000000, AvailBytes: 6144000000, UsedPercent: 40},
+		{Filesystem: "/dev/sdb1", Mount: "/mnt/media files", SizeBytes: 204800000000, UsedBytes: 51200000000, AvailBytes: 153600000000, UsedPercent: 25},
+	}
+	if len(st.Disks) != len(want) || st.Disks[0] != want[0] || st.Disks[1] != want[1] {
+		t.Errorf("node_status disks = %+v, want %+v", st.Disks, want)
+	}
+	wantMem := agent.MemoryUsage{TotalBytes: 16000000000, UsedBytes: 6000000000, AvailableBytes: 9500000000, SwapTotalBytes: 2000000000, SwapUsedBytes: 100000000}
+	if st.Memory != wantMem {
+		t.Errorf("node_status memory = %+v, want %+v", st.Memory, wantMem)
+	}
+
+	nodes.ran = nil
+	res, err = a.Dispatch(ctx, "node_file_read", map[string]interface{}{"node": "nas", "path": "/etc/host name"})
+	if err != nil || res != "nas.lan\n" {
+		t.Errorf("node_file_read = %q, %v", res, err)
+	}
+	if len(nodes.ran) != 1 || nodes.ran[0] != "nas: head -c 65537 -- '/etc/host name'" {
+		t.Errorf("node_file_read ran %q", nodes.ran)
+	}
+	for _, p := range []string{"/etc/shadow", "/etc//shadow", "/etc/../root/.ssh/id_ed25519", "etc/hostname"} {
+		nodes.ran = nil
+		if _, err := a.Dispatch(ctx, "node_file_read", map[string]interface{}{"node": "nas", "path": p}); err == nil || len(nodes.ran) > 0 {
+			t.Errorf("node_file_read %q: err = %v, ran %q; want refused", p, err, nodes.ran)
+		}
+	}
+}
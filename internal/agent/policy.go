@@ -0,0 +1,321 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// permitted checks cmd against the node's policy. The command line is split
+// into its simple commands, each of which must pass on its own. Variables,
+// command substitution and redirection are refused whatever the policy, as
+// they would run or write what the command line does not show.
+//
+// An allow list is matched against each command as written, so it is the
+// only real limit on what runs. Deny lists are best-effort: they also look
+// through wrappers such as sudo, env and sh -c and ignore the directory of
+// the program, but cannot foresee every way of running a command.
+func permitted(n config.NodeConfig, cmd string) error {
+	if strings.ContainsAny(cmd, "`$<>") {
+		return fmt.Errorf("variables, command substitution and redirection are not allowed on nodes")
+	}
+	cmds, err := splitCommands(cmd)
+	if err != nil {
+		return err
+	}
+	for _, words := range cmds {
+		line := strings.Join(words, " ")
+		p, err := deniedBy(n.Deny, words)
+		if err != nil {
+			return fmt.Errorf("%q: %w", line, err)
+		}
+		if p != "" {
+			return fmt.Errorf("%q is denied by the node's policy (%s)", line, p)
+		}
+		if len(n.Allow) > 0 && !allowedBy(n.Allow, words) {
+			return fmt.Errorf("%q is not allowed by the node's policy (allowed: %s)", line, strings.Join(n.Allow, ", "))
+		}
+	}
+	return nil
+}
+
+// allowedBy reports whether words match one of the allow patterns in full.
+func allowedBy(allow, words []string) bool {
+	words = cleanPaths(words)
+	for _, p := range allow {
+		if matchWords(strings.Fields(p), words, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedBy returns the deny pattern that words start with, if any. Leading
+// assignments, shell keywords and wrapper commands are looked through, and
+// the scripts of sh -c and eval are checked command by command.
+func deniedBy(deny, words []string) (string, error) {
+	for len(words) > 0 {
+		base := cleanPaths(words)
+		base[0] = path.Base(base[0])
+		for _, p := range deny {
+			pw := strings.Fields(p)
+			if len(pw) > 0 {
+				pw[0] = path.Base(pw[0])
+			}
+			if matchWords(pw, base, true) {
+				return p, nil
+			}
+		}
+
+		name := base[0]
+		w, wrapper := wrappers[name]
+		switch {
+		case isAssignment(words[0]) || shellKeywords[words[0]]:
+			words = words[1:]
+		case wrapper:
+			words = skipOptions(words[1:], w.opts)
+			for i := 0; i < w.operands && len(words) > 0; i++ {
+				words = words[1:]
+			}
+		case shells[name]:
+			script, ok := shellScript(words[1:])
+			if !ok {
+				return "", nil
+			}
+			return deniedIn(deny, script)
+		case name == "eval":
+			return deniedIn(deny, strings.Join(words[1:], " "))
+		default:
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// deniedIn is deniedBy for each command of a command line.
+func deniedIn(deny []string, line string) (string, error) {
+	cmds, err := splitCommands(line)
+	if err != nil {
+		return "", err
+	}
+	for _, words := range cmds {
+		if p, err := deniedBy(deny, words); p != "" || err != nil {
+			return p, err
+		}
+	}
+	return "", nil
+}
+
+// wrappers are commands that run the command given in their arguments,
+// with the short options that take a value and the operands before the
+// command.
+var wrappers = map[string]struct {
+	opts     string
+	operands int
+}{
+	"sudo":    {opts: "CDghpRrTtUu"},
+	"doas":    {opts: "Cu"},
+	"env":     {opts: "CSu"},
+	"nice":    {opts: "n"},
+	"ionice":  {opts: "cnp"},
+	"nohup":   {},
+	"exec":    {opts: "a"},
+	"command": {},
+	"builtin": {},
+	"time":    {opts: "fo"},
+	"timeout": {opts: "ks", operands: 1},
+	"stdbuf":  {opts: "eio"},
+	"xargs":   {opts: "adEIiLlnPs"},
+}
+
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "ash": true, "ksh": true, "zsh": true}
+
+var shellKeywords = map[string]bool{
+	"!": true, "{": true, "}": true, "if": true, "then": true, "elif": true, "else": true,
+	"while": true, "until": true, "do": true,
+}
+
+// skipOptions drops the leading options of a wrapper's arguments, with the
+// values of those in withValue.
+func skipOptions(words []string, withValue string) []string {
+	for len(words) > 0 {
+		w := words[0]
+		if w == "--" {
+			return words[1:]
+		}
+		if len(w) < 2 || w[0] != '-' {
+			return words
+		}
+		words = words[1:]
+		if strings.HasPrefix(w, "--") {
+			continue
+		}
+		// The first option of a group that takes a value takes the rest of
+		// the word, or the next word if there is no rest.
+		for i := 1; i < len(w); i++ {
+			if strings.IndexByte(withValue, w[i]) >= 0 {
+				if i == len(w)-1 && len(words) > 0 {
+					words = words[1:]
+				}
+				break
+			}
+		}
+	}
+	return words
+}
+
+// shellScript returns the script of a shell run with -c.
+func shellScript(args []string) (string, bool) {
+	c := false
+	for _, a := range args {
+		if len(a) > 1 && (a[0] == '-' || a[0] == '+') && !strings.HasPrefix(a, "--") {
+			c = c || strings.ContainsRune(a[1:], 'c')
+			continue
+		}
+		return a, c
+	}
+	return "", false
+}
+
+// isAssignment reports whether w sets a variable, as in NAME=value.
+func isAssignment(w string) bool {
+	i := strings.IndexByte(w, '=')
+	if i <= 0 {
+		return false
+	}
+	for j, r := range w[:i] {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || j > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanPaths returns a copy of words with absolute paths cleaned, so that
+// /etc/../root is matched as /root.
+func cleanPaths(words []string) []string {
+	out := make([]string, len(words))
+	for i, w := range words {
+		if strings.HasPrefix(w, "/") {
+			w = path.Clean(w)
+		}
+		out[i] = w
+	}
+	return out
+}
+
+// splitCommands splits a command line into its simple commands at unquoted
+// ;, &, |, parentheses and newlines, and each command into words with the
+// quoting removed, as a POSIX shell does.
+func splitCommands(line string) ([][]string, error) {
+	var (
+		cmds   [][]string
+		words  []string
+		word   strings.Builder
+		inWord bool
+	)
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			cmds = append(cmds, words)
+			words = nil
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\'':
+			j := strings.IndexByte(line[i+1:], '\'')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			word.WriteString(line[i+1 : i+1+j])
+			inWord = true
+			i += j + 1
+		case c == '"':
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unterminated quote")
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\\n", line[i+1]) >= 0 {
+					i++
+					if line[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(line[i])
+			}
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				if line[i] != '\n' {
+					word.WriteByte(line[i])
+					inWord = true
+				}
+			}
+		case c == ' ' || c == '\t':
+			endWord()
+		case strings.IndexByte(";&|()\n", c) >= 0:
+			endCommand()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return cmds, nil
+}
+
+// matchWords reports whether words match pattern word by word. A * within a
+// pattern word matches any run of characters of one word, and a * on its own
+// at the end of the pattern any remaining words. With prefix set, words may
+// go on past the end of the pattern.
+func matchWords(pattern, words []string, prefix bool) bool {
+	if len(pattern) == 0 {
+		return false
+	}
+	for i, p := range pattern {
+		if p == "*" && i == len(pattern)-1 {
+			return true
+		}
+		if i >= len(words) || !matchWord(p, words[i]) {
+			return false
+		}
+	}
+	return prefix || len(words) == len(pattern)
+}
+
+// matchWord reports whether s matches pattern as a whole, where * matches
+// any run of characters and everything else itself.
+func matchWord(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
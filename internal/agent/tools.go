@@ -2,14 +2,71 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/fjrt/poeai/internal/memory"
 )
 
 func (a *Agent) registerCoreTools() {
-	a.RegisterTool("memory_write", a.toolMemoryWrite)
-	a.RegisterTool("memory_search", a.toolMemorySearch)
+	a.Register(Tool{
+		Name:        "memory_write",
+		Description: "Store a new memory about the owner, the homelab or a conversation.",
+		Parameters: object(map[string]interface{}{
+			"content": prop("string", "The memory, written as a self-contained sentence."),
+			"type": map[string]interface{}{
+				"type":        "string",
+				"description": "Kind of memory. Defaults to episodic.",
+				"enum":        []string{"episodic", "semantic", "fact", "procedural"},
+			},
+		}, "content"),
+		Func: a.toolMemoryWrite,
+	})
+	a.Register(Tool{
+		Name:        "memory_search",
+		Description: "Search stored memories relevant to a query.",
+		Parameters: object(map[string]interface{}{
+			"query": prop("string", "What to look for."),
+			"limit": prop("integer", "Maximum number of results. Defaults to 5."),
+		}, "query"),
+		Func: a.toolMemorySearch,
+	})
+	a.Register(Tool{
+		Name:        "memory_update",
+		Description: "Correct or re-prioritize a stored memory, addressed by the ID shown by memory_search.",
+		Parameters: object(map[string]interface{}{
+			"id":         prop("string", "ID of the memory."),
+			"content":    prop("string", "New content, replacing the old."),
+			"importance": prop("number", "New importance between 0 and 1."),
+		}, "id"),
+		Func: a.toolMemoryUpdate,
+	})
+	a.Register(Tool{
+		Name:        "memory_delete",
+		Description: "Delete a stored memory that is wrong or no longer relevant.",
+		Parameters: object(map[string]interface{}{
+			"id": prop("string", "ID of the memory."),
+		}, "id"),
+		Func: a.toolMemoryDelete,
+	})
+	a.Register(Tool{
+		Name:        "fact_set",
+		Description: "Record a fact about the owner or the homelab under a dotted key such as homelab.ha-server.os.",
+		Parameters: object(map[string]interface{}{
+			"key":        prop("string", "Dotted key."),
+			"value":      prop("string", "The fact."),
+			"confidence": prop("number", "Confidence between 0 and 1. Defaults to 1."),
+		}, "key", "value"),
+		Func: a.toolFactSet,
+	})
+	a.Register(Tool{
+		Name:        "fact_get",
+		Description: "Look up a fact by its dotted key.",
+		Parameters: object(map[string]interface{}{
+			"key": prop("string", "Dotted key."),
+		}, "key"),
+		Func: a.toolFactGet,
+	})
 }
 
 func (a *Agent) toolMemoryWrite(ctx context.Context, params map[string]interface{}) (string, error) {
@@ -55,7 +112,81 @@ func (a *Agent) toolMemorySearch(ctx context.Context, params map[string]interfac
 
 	out := "Relevant memories:\n"
 	for _, r := range results {
-		out += fmt.Sprintf("- [%s] %s\n", r.CreatedAt.Format("2006-01-02"), r.Content)
+		out += fmt.Sprintf("- [%s] (id %s) %s\n", r.CreatedAt.Format("2006-01-02"), r.ID, r.Content)
 	}
 	return out, nil
 }
+
+func (a *Agent) toolMemoryUpdate(ctx context.Context, params map[string]interface{}) (string, error) {
+	id, ok := params["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing id")
+	}
+	var u memory.MemoryUpdate
+	if content, ok := params["content"].(string); ok {
+		u.Content = &content
+	}
+	if importance, ok := params["importance"].(float64); ok {
+		u.Importance = &importance
+	}
+	if u.Content == nil && u.Importance == nil {
+		return "", fmt.Errorf("nothing to update: give content or importance")
+	}
+
+	if _, err := a.memory.Update(ctx, id, u); err != nil {
+		if errors.Is(err, memory.ErrNotFound) {
+			return "", fmt.Errorf("no memory with ID %s", id)
+		}
+		return "", err
+	}
+	return fmt.Sprintf("Memory %s updated.", id), nil
+}
+
+func (a *Agent) toolMemoryDelete(ctx context.Context, params map[string]interface{}) (string, error) {
+	id, ok := params["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing id")
+	}
+	if err := a.memory.Delete(ctx, id); err != nil {
+		if errors.Is(err, memory.ErrNotFound) {
+			return "", fmt.Errorf("no memory with ID %s", id)
+		}
+		return "", err
+	}
+	return fmt.Sprintf("Memory %s deleted.", id), nil
+}
+
+func (a *Agent) toolFactSet(ctx context.Context, params map[string]interface{}) (string, error) {
+	key, ok := params["key"].(string)
+	if !ok || key == "" {
+		return "", fmt.Errorf("missing key")
+	}
+	value, ok := params["value"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing value")
+	}
+	confidence := 1.0
+	if c, ok := params["confidence"].(float64); ok {
+		confidence = c
+	}
+
+	if err := a.memory.SetFact(ctx, key, value, confidence); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Fact %s set.", key), nil
+}
+
+func (a *Agent) toolFactGet(ctx context.Context, params map[string]interface{}) (string, error) {
+	key, ok := params["key"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing key")
+	}
+	f, ok, err := a.memory.Fact(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return fmt.Sprintf("No fact stored under %s.", key), nil
+	}
+	return fmt.Sprintf("%s = %s (confidence %.2f, updated %s)", f.Key, f.Value, f.Confidence, f.UpdatedAt.Format("2006-01-02")), nil
+}
@@ -16,12 +16,68 @@ const (
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the tools an assistant message asks to run.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a RoleTool message to the call it answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall is a request from the model to run a tool.
+type ToolCall struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+// Tool declares a tool the model may call. Parameters is a JSON schema
+// describing the arguments object.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// Request is a full chat request.
+type Request struct {
+	Messages []Message
+	Tools    []Tool
+}
+
+// Response is a complete, non-streamed reply.
+type Response struct {
+	Message    Message // always RoleAssistant; may carry ToolCalls
+	StopReason string
+	Usage      Usage
+}
+
+// Usage reports token consumption for one completion.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// StreamEvent is one step of a streamed completion. Text arrives in Delta and
+// each tool call arrives whole in ToolCall once the model has finished it;
+// the last event has Done set and carries the stop reason and usage. A stream
+// that fails mid-way ends with an event carrying Err instead.
+type StreamEvent struct {
+	Delta      string
+	ToolCall   *ToolCall
+	Done       bool
+	StopReason string
+	Usage      Usage
+	Err        error
 }
 
 // Client is the interface for all AI providers.
 type Client interface {
 	// Completion returns a response from the model.
 	Completion(ctx context.Context, messages []Message) (string, error)
+	// Chat is Completion with tool declarations and a structured reply.
+	Chat(ctx context.Context, req Request) (Response, error)
+	// Stream returns a response from the model as it is generated. The
+	// channel is closed after the final event.
+	Stream(ctx context.Context, req Request) (<-chan StreamEvent, error)
 	// Name returns the provider name.
 	Name() string
 }
@@ -32,49 +88,53 @@ type Provider struct {
 	Name        string   `json:"name"`
 	Models      []string `json:"models"`
 	Description string   `json:"description"`
+	// Local providers run on the homelab: they need no credentials and
+	// accept any model name the server has pulled, not just Models.
+	Local bool `json:"local"`
 }
 
-// GetProviders returns a list of recommended providers and models, inspired by catwalk.
-func GetProviders() []Provider {
-	return []Provider{
-		{
-			ID:   "anthropic",
-			Name: "Anthropic",
-			Models: []string{
-				"claude-3-5-sonnet-20240620",
-				"claude-3-opus-20240229",
-				"claude-3-haiku-20240307",
-			},
-			Description: "Best-in-class reasoning and coding capabilities.",
+// The built-in providers, in the order they are offered during onboarding.
+// Recommended models are inspired by catwalk.
+func init() {
+	Register(Provider{
+		ID:   "anthropic",
+		Name: "Anthropic",
+		Models: []string{
+			"claude-opus-4-6",
+			"claude-3-5-sonnet-20240620",
+			"claude-3-opus-20240229",
+			"claude-3-haiku-20240307",
 		},
-		{
-			ID:   "google",
-			Name: "Google Gemini",
-			Models: []string{
-				"gemini-1.5-pro",
-				"gemini-1.5-flash",
-			},
-			Description: "Large context window and fast performance.",
+		Description: "Best-in-class reasoning and coding capabilities.",
+	}, NewAnthropic)
+	Register(Provider{
+		ID:   "google",
+		Name: "Google Gemini",
+		Models: []string{
+			"gemini-1.5-pro",
+			"gemini-1.5-flash",
 		},
-		{
-			ID:   "openai",
-			Name: "OpenAI",
-			Models: []string{
-				"gpt-4o",
-				"gpt-4-turbo",
-				"gpt-3.5-turbo",
-			},
-			Description: "Industry standard for performance and instruction following.",
+		Description: "Large context window and fast performance.",
+	}, NewGemini)
+	Register(Provider{
+		ID:   "openai",
+		Name: "OpenAI",
+		Models: []string{
+			"gpt-4o",
+			"gpt-4-turbo",
+			"gpt-3.5-turbo",
 		},
-		{
-			ID:   "ollama",
-			Name: "Ollama (Local)",
-			Models: []string{
-				"llama3",
-				"mistral",
-				"phi3",
-			},
-			Description: "Run models locally on your homelab. No API key needed.",
+		Description: "Industry standard for performance and instruction following.",
+	}, NewOpenAI)
+	Register(Provider{
+		ID:   "ollama",
+		Name: "Ollama (Local)",
+		Models: []string{
+			"llama3",
+			"mistral",
+			"phi3",
 		},
-	}
+		Description: "Run models locally on your homelab. No API key needed.",
+		Local:       true,
+	}, NewOpenAI)
 }
@@ -0,0 +1,322 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion = "2023-06-01"
+)
+
+// Anthropic is a Client for the Anthropic Messages API.
+type Anthropic struct {
+	baseURL   string
+	model     string
+	maxTokens int
+	apiKey    string
+	token     string
+	http      *http.Client
+}
+
+// NewAnthropic creates an Anthropic client from the LLM configuration. The
+// "anthropic" entry of cfg.Auth selects between an API key and an OAuth token.
+func NewAnthropic(cfg config.LLMConfig) (Client, error) {
+	auth := cfg.Auth["anthropic"]
+	if auth == nil {
+		return nil, fmt.Errorf("anthropic: no auth configured")
+	}
+	c := &Anthropic{
+		baseURL:   trimBaseURL(auth.BaseURL, anthropicBaseURL),
+		model:     cfg.Model,
+		maxTokens: cfg.MaxTokens,
+		http:      defaultHTTPClient,
+	}
+	if c.maxTokens <= 0 {
+		c.maxTokens = 4096
+	}
+	var err error
+	switch auth.Strategy {
+	case "apikey", "":
+		if c.apiKey, err = credential("anthropic", "api_key", auth.APIKey); err != nil {
+			return nil, err
+		}
+	case "oauth", "token":
+		if c.token, err = credential("anthropic", "token", auth.Token); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("anthropic: unsupported auth strategy %q", auth.Strategy)
+	}
+	return c, nil
+}
+
+func (c *Anthropic) Name() string { return "anthropic" }
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block: text, tool_use or tool_result.
+type anthropicBlock struct {
+	Type      string                 `json:"type"`
+	Text      string                 `json:"text,omitempty"`
+	ID        string                 `json:"id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Input     map[string]interface{} `json:"input,omitempty"`
+	ToolUseID string                 `json:"tool_use_id,omitempty"`
+	Content   string                 `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// anthropicStreamEvent covers the fields used from every SSE event type.
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Completion sends the conversation to /v1/messages and returns the text of
+// the reply.
+func (c *Anthropic) Completion(ctx context.Context, messages []Message) (string, error) {
+	resp, err := c.Chat(ctx, Request{Messages: messages})
+	return resp.Message.Content, err
+}
+
+// Chat sends req to /v1/messages. System messages are lifted into the
+// request's system field and tool results are sent as tool_result blocks.
+func (c *Anthropic) Chat(ctx context.Context, req Request) (Response, error) {
+	body, err := c.request(req)
+	if err != nil {
+		return Response{}, err
+	}
+	resp, err := c.post(ctx, body)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var out anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, fmt.Errorf("anthropic: decode response: %w", err)
+	}
+	msg := Message{Role: RoleAssistant}
+	for _, block := range out.Content {
+		switch block.Type {
+		case "text":
+			msg.Content += block.Text
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: block.Input})
+		}
+	}
+	return Response{
+		Message:    msg,
+		StopReason: out.StopReason,
+		Usage:      Usage{InputTokens: out.Usage.InputTokens, OutputTokens: out.Usage.OutputTokens},
+	}, nil
+}
+
+// Stream is Chat with "stream": true, translating the Messages API
+// server-sent events into StreamEvents.
+func (c *Anthropic) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	body, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	body.Stream = true
+	resp, err := c.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		final := StreamEvent{Done: true}
+		// tool_use blocks being assembled, by content block index
+		calls := make(map[int]*ToolCall)
+		args := make(map[int]*strings.Builder)
+
+		err := readSSE(resp.Body, func(_, data string) error {
+			var ev anthropicStreamEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				return fmt.Errorf("decode event: %w", err)
+			}
+			switch ev.Type {
+			case "message_start":
+				final.Usage.InputTokens = ev.Message.Usage.InputTokens
+			case "content_block_start":
+				if ev.ContentBlock.Type == "tool_use" {
+					calls[ev.Index] = &ToolCall{ID: ev.ContentBlock.ID, Name: ev.ContentBlock.Name}
+					args[ev.Index] = &strings.Builder{}
+				}
+			case "content_block_delta":
+				switch ev.Delta.Type {
+				case "text_delta":
+					if !emit(ctx, ch, StreamEvent{Delta: ev.Delta.Text}) {
+						return ctx.Err()
+					}
+				case "input_json_delta":
+					if b := args[ev.Index]; b != nil {
+						b.WriteString(ev.Delta.PartialJSON)
+					}
+				}
+			case "content_block_stop":
+				call := calls[ev.Index]
+				if call == nil {
+					return nil
+				}
+				delete(calls, ev.Index)
+				if raw := args[ev.Index].String(); raw != "" {
+					if err := json.Unmarshal([]byte(raw), &call.Arguments); err != nil {
+						return fmt.Errorf("decode tool input: %w", err)
+					}
+				}
+				if !emit(ctx, ch, StreamEvent{ToolCall: call}) {
+					return ctx.Err()
+				}
+			case "message_delta":
+				final.StopReason = ev.Delta.StopReason
+				final.Usage.OutputTokens = ev.Usage.OutputTokens
+			case "message_stop":
+				return io.EOF
+			case "error":
+				return &APIError{Provider: "anthropic", StatusCode: resp.StatusCode, Type: ev.Error.Type, Message: ev.Error.Message}
+			}
+			return nil
+		})
+		if err != nil {
+			emit(ctx, ch, StreamEvent{Err: fmt.Errorf("anthropic: %w", err)})
+			return
+		}
+		emit(ctx, ch, final)
+	}()
+	return ch, nil
+}
+
+func (c *Anthropic) request(req Request) (anthropicRequest, error) {
+	body := anthropicRequest{Model: c.model, MaxTokens: c.maxTokens}
+	var system []string
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, m.Content)
+		case RoleUser:
+			body.Messages = append(body.Messages, anthropicMessage{
+				Role:    "user",
+				Content: []anthropicBlock{{Type: "text", Text: m.Content}},
+			})
+		case RoleAssistant:
+			var blocks []anthropicBlock
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := tc.Arguments
+				if input == nil {
+					input = map[string]interface{}{}
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Name, Input: input})
+			}
+			body.Messages = append(body.Messages, anthropicMessage{Role: "assistant", Content: blocks})
+		case RoleTool:
+			// Results for parallel calls must share a single user turn.
+			block := anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}
+			if n := len(body.Messages); n > 0 && body.Messages[n-1].Role == "user" && body.Messages[n-1].Content[0].Type == "tool_result" {
+				body.Messages[n-1].Content = append(body.Messages[n-1].Content, block)
+			} else {
+				body.Messages = append(body.Messages, anthropicMessage{Role: "user", Content: []anthropicBlock{block}})
+			}
+		default:
+			return body, fmt.Errorf("anthropic: unsupported role %q", m.Role)
+		}
+	}
+	body.System = strings.Join(system, "\n\n")
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: t.Parameters})
+	}
+	return body, nil
+}
+
+// post sends req and turns non-200 answers into an *APIError.
+func (c *Anthropic) post(ctx context.Context, req anthropicRequest) (*http.Response, error) {
+	resp, err := postJSON(ctx, c.http, c.baseURL+"/v1/messages", c.header(), req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e anthropicError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, newAPIError("anthropic", resp, e.Error.Type, e.Error.Message)
+	}
+	return resp, nil
+}
+
+func (c *Anthropic) header() http.Header {
+	h := http.Header{}
+	h.Set("anthropic-version", anthropicVersion)
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+		h.Set("anthropic-beta", "oauth-2025-04-20")
+	} else {
+		h.Set("x-api-key", c.apiKey)
+	}
+	return h
+}
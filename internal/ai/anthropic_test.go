@@ -0,0 +1,180 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+)
+
+func anthropicConfig(url string) config.LLMConfig {
+	return config.LLMConfig{
+		Provider: "anthropic",
+		Model:    "claude-opus-4-6",
+		Auth: map[string]*config.Auth{
+			"anthropic": {Strategy: "apikey", APIKey: "sk-test", BaseURL: url},
+		},
+	}
+}
+
+func TestAnthropic_Completion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s, want /v1/messages", r.URL.Path)
+		}
+		if got := r.Header.Get("x-api-key"); got != "sk-test" {
+			t.Errorf("x-api-key = %q", got)
+		}
+		var body struct {
+			System   string `json:"system"`
+			Messages []struct {
+				Role string `json:"role"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.System != "You are Poe." {
+			t.Errorf("system = %q, want %q", body.System, "You are Poe.")
+		}
+		if len(body.Messages) != 1 || body.Messages[0].Role != "user" {
+			t.Errorf("messages = %+v, want a single user message", body.Messages)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"Good evening."}],"stop_reason":"end_turn"}`))
+	}))
+	defer ts.Close()
+
+	c, err := ai.NewAnthropic(anthropicConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("NewAnthropic() error = %v", err)
+	}
+	got, err := c.Completion(context.Background(), []ai.Message{
+		{Role: ai.RoleSystem, Content: "You are Poe."},
+		{Role: ai.RoleUser, Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("Completion() error = %v", err)
+	}
+	if got != "Good evening." {
+		t.Errorf("Completion() = %q", got)
+	}
+}
+
+func TestAnthropic_Overloaded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(529)
+		w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewAnthropic(anthropicConfig(ts.URL))
+	_, err := c.Completion(context.Background(), []ai.Message{{Role: ai.RoleUser, Content: "Hello"}})
+	if !errors.Is(err, ai.ErrOverloaded) {
+		t.Fatalf("Completion() error = %v, want ErrOverloaded", err)
+	}
+	var apiErr *ai.APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 3*time.Second {
+		t.Errorf("RetryAfter = %v, want 3s", apiErr)
+	}
+}
+
+func TestAnthropic_Stream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":12}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Good "}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"evening."}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewAnthropic(anthropicConfig(ts.URL))
+	events, err := c.Stream(context.Background(), ai.Request{Messages: []ai.Message{{Role: ai.RoleUser, Content: "Hello"}}})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	text, final, err := ai.Collect(events)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if text != "Good evening." {
+		t.Errorf("text = %q", text)
+	}
+	if !final.Done || final.StopReason != "end_turn" || final.Usage.InputTokens != 12 || final.Usage.OutputTokens != 3 {
+		t.Errorf("final event = %+v", final)
+	}
+}
+
+func TestAnthropic_ChatToolUse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content []struct {
+					Type      string `json:"type"`
+					ToolUseID string `json:"tool_use_id"`
+				} `json:"content"`
+			} `json:"messages"`
+			Tools []struct {
+				Name        string                 `json:"name"`
+				InputSchema map[string]interface{} `json:"input_schema"`
+			} `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Tools) != 1 || body.Tools[0].Name != "memory_search" || body.Tools[0].InputSchema["type"] != "object" {
+			t.Errorf("tools = %+v", body.Tools)
+		}
+		// user, assistant(tool_use), user(tool_result x2)
+		if len(body.Messages) != 3 || len(body.Messages[2].Content) != 2 || body.Messages[2].Content[1].ToolUseID != "toolu_2" {
+			t.Errorf("messages = %+v", body.Messages)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_3","name":"memory_search","input":{"query":"thermostat"}}],"stop_reason":"tool_use"}`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewAnthropic(anthropicConfig(ts.URL))
+	resp, err := c.Chat(context.Background(), ai.Request{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "What broke?"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{
+				{ID: "toolu_1", Name: "memory_search", Arguments: map[string]interface{}{"query": "esphome"}},
+				{ID: "toolu_2", Name: "memory_search", Arguments: map[string]interface{}{"query": "ha-server"}},
+			}},
+			{Role: ai.RoleTool, ToolCallID: "toolu_1", Content: "No relevant memories found."},
+			{Role: ai.RoleTool, ToolCallID: "toolu_2", Content: "No relevant memories found."},
+		},
+		Tools: []ai.Tool{{
+			Name:       "memory_search",
+			Parameters: map[string]interface{}{"type": "object", "properties": map[string]interface{}{"query": map[string]interface{}{"type": "string"}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.StopReason != "tool_use" || resp.Message.Content != "Let me check." {
+		t.Errorf("Chat() = %+v", resp)
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Arguments["query"] != "thermostat" {
+		t.Errorf("ToolCalls = %+v", resp.Message.ToolCalls)
+	}
+}
@@ -0,0 +1,118 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// Embedder turns text into vectors for semantic search. Vectors from the same
+// Model are comparable with each other; vectors from different models are not.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+}
+
+// NewEmbedder builds an Embedder from a "provider/model" spec such as
+// "ollama/nomic-embed-text", using the provider's entry in auth for the base
+// URL and credentials. Providers speaking the OpenAI wire format are supported.
+func NewEmbedder(spec string, auth map[string]*config.Auth) (Embedder, error) {
+	provider, model, ok := strings.Cut(spec, "/")
+	if !ok || provider == "" || model == "" {
+		return nil, fmt.Errorf("embedding model %q: want provider/model", spec)
+	}
+	if _, ok := openAIBaseURLs[provider]; !ok {
+		return nil, fmt.Errorf("embedding model %q: provider %s does not support embeddings", spec, provider)
+	}
+	c, err := NewOpenAI(config.LLMConfig{Provider: provider, Model: model, Auth: auth})
+	if err != nil {
+		return nil, err
+	}
+	return c.(*OpenAI), nil
+}
+
+// Model returns the provider-qualified model name, e.g. "ollama/nomic-embed-text".
+func (c *OpenAI) Model() string { return c.provider + "/" + c.model }
+
+// Embed calls the /embeddings endpoint.
+func (c *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{c.model, texts}
+	resp, err := postJSON(ctx, c.http, c.baseURL+"/embeddings", c.header(), body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e openAIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		typ, msg := e.decode()
+		return nil, newAPIError(c.provider, resp, typ, msg)
+	}
+
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s: decode embeddings: %w", c.provider, err)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("%s: got %d embeddings for %d inputs", c.provider, len(out.Data), len(texts))
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vecs) {
+			return nil, fmt.Errorf("%s: embedding index %d out of range", c.provider, d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
+}
+
+// HashEmbedder is a deterministic Embedder for tests. Each word is hashed into
+// one of Dim buckets, so texts sharing words are similar and unrelated texts
+// are close to orthogonal.
+type HashEmbedder struct {
+	Dim int
+}
+
+func (h HashEmbedder) Model() string { return fmt.Sprintf("hash/%d", h.Dim) }
+
+func (h HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, h.Dim)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, w := range words {
+			f := fnv.New32a()
+			f.Write([]byte(w))
+			v[f.Sum32()%uint32(h.Dim)]++
+		}
+		var norm float64
+		for _, x := range v {
+			norm += float64(x * x)
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for j := range v {
+				v[j] = float32(float64(v[j]) / norm)
+			}
+		}
+		vecs[i] = v
+	}
+	return vecs, nil
+}
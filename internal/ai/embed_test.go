@@ -0,0 +1,52 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+)
+
+func TestNewEmbedder_Ollama(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("path = %s, want /v1/embeddings", r.URL.Path)
+		}
+		var body struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Model != "nomic-embed-text" || len(body.Input) != 2 {
+			t.Errorf("body = %+v", body)
+		}
+		// Out of order on purpose: results are placed by index.
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer ts.Close()
+
+	e, err := ai.NewEmbedder("ollama/nomic-embed-text", map[string]*config.Auth{
+		"ollama": {Strategy: "none", BaseURL: ts.URL + "/v1"},
+	})
+	if err != nil {
+		t.Fatalf("NewEmbedder() error = %v", err)
+	}
+	if e.Model() != "ollama/nomic-embed-text" {
+		t.Errorf("Model() = %q", e.Model())
+	}
+	vecs, err := e.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if vecs[0][0] != 1 || vecs[1][1] != 1 {
+		t.Errorf("Embed() = %v", vecs)
+	}
+
+	if _, err := ai.NewEmbedder("anthropic/claude", nil); err == nil {
+		t.Error("NewEmbedder() accepted a provider without embeddings")
+	}
+}
@@ -0,0 +1,73 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Sentinel errors for transient provider conditions. Match them with errors.Is.
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrOverloaded  = errors.New("provider overloaded")
+)
+
+// ErrMissingCredentials is returned by NewClient when the selected auth
+// strategy has no key or token configured.
+var ErrMissingCredentials = errors.New("missing credentials")
+
+// APIError is returned when a provider answers with a non-2xx status.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Type       string // provider specific error type, e.g. "overloaded_error"
+	Message    string
+	RetryAfter time.Duration // zero if the provider did not send Retry-After
+}
+
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s: %s (%d %s)", e.Provider, e.Message, e.StatusCode, e.Type)
+	}
+	return fmt.Sprintf("%s: %s (%d)", e.Provider, e.Message, e.StatusCode)
+}
+
+// Is reports whether the error matches ErrRateLimited or ErrOverloaded.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests || e.Type == "rate_limit_error"
+	case ErrOverloaded:
+		// 529 is Anthropic's overloaded status; the others use 503.
+		return e.StatusCode == 529 || e.StatusCode == http.StatusServiceUnavailable || e.Type == "overloaded_error"
+	}
+	return false
+}
+
+func newAPIError(provider string, resp *http.Response, typ, msg string) *APIError {
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Type:       typ,
+		Message:    msg,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,321 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/google/uuid"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// Gemini is a Client for the Google Gemini generateContent REST API.
+type Gemini struct {
+	baseURL   string
+	model     string
+	maxTokens int
+	apiKey    string
+	token     string
+	options   map[string]interface{}
+	http      *http.Client
+}
+
+// NewGemini creates a Gemini client from the "google" entry of cfg.Auth. The
+// apikey strategy sends x-goog-api-key; oauth sends a bearer token.
+func NewGemini(cfg config.LLMConfig) (Client, error) {
+	auth := cfg.Auth["google"]
+	if auth == nil {
+		return nil, fmt.Errorf("google: no auth configured")
+	}
+	c := &Gemini{
+		baseURL:   trimBaseURL(auth.BaseURL, geminiBaseURL),
+		model:     cfg.Model,
+		maxTokens: cfg.MaxTokens,
+		options:   auth.Options,
+		http:      defaultHTTPClient,
+	}
+	var err error
+	switch auth.Strategy {
+	case "apikey", "":
+		if c.apiKey, err = credential("google", "api_key", auth.APIKey); err != nil {
+			return nil, err
+		}
+	case "oauth", "token":
+		if c.token, err = credential("google", "token", auth.Token); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("google: unsupported auth strategy %q", auth.Strategy)
+	}
+	return c, nil
+}
+
+func (c *Gemini) Name() string { return "google" }
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	Tools             []geminiTool           `json:"tools,omitempty"`
+	GenerationConfig  map[string]interface{} `json:"generationConfig,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// message converts the first candidate. Gemini does not identify function
+// calls, so IDs are generated here.
+func (r geminiResponse) message() Message {
+	msg := Message{Role: RoleAssistant}
+	if len(r.Candidates) == 0 {
+		return msg
+	}
+	var sb strings.Builder
+	for _, p := range r.Candidates[0].Content.Parts {
+		sb.WriteString(p.Text)
+		if p.FunctionCall != nil {
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:        "call_" + uuid.NewString(),
+				Name:      p.FunctionCall.Name,
+				Arguments: p.FunctionCall.Args,
+			})
+		}
+	}
+	msg.Content = sb.String()
+	return msg
+}
+
+func (r geminiResponse) usage() Usage {
+	return Usage{InputTokens: r.UsageMetadata.PromptTokenCount, OutputTokens: r.UsageMetadata.CandidatesTokenCount}
+}
+
+type geminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// Completion calls models/{model}:generateContent and returns the text of the
+// first candidate.
+func (c *Gemini) Completion(ctx context.Context, messages []Message) (string, error) {
+	resp, err := c.Chat(ctx, Request{Messages: messages})
+	return resp.Message.Content, err
+}
+
+// Chat calls models/{model}:generateContent. System messages become the
+// systemInstruction, assistant turns are sent with the "model" role and tools
+// are declared as functionDeclarations.
+func (c *Gemini) Chat(ctx context.Context, req Request) (Response, error) {
+	body, err := c.request(req)
+	if err != nil {
+		return Response{}, err
+	}
+	resp, err := c.post(ctx, "generateContent", body)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var out geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, fmt.Errorf("google: decode response: %w", err)
+	}
+	if len(out.Candidates) == 0 {
+		return Response{}, fmt.Errorf("google: response has no candidates")
+	}
+	return Response{
+		Message:    out.message(),
+		StopReason: out.Candidates[0].FinishReason,
+		Usage:      out.usage(),
+	}, nil
+}
+
+// Stream calls models/{model}:streamGenerateContent with alt=sse. Each event
+// is a partial generateContent response; function calls arrive whole.
+func (c *Gemini) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	body, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(ctx, "streamGenerateContent?alt=sse", body)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		final := StreamEvent{Done: true}
+		err := readSSE(resp.Body, func(_, data string) error {
+			var chunk geminiResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return fmt.Errorf("decode chunk: %w", err)
+			}
+			if u := chunk.usage(); u.InputTokens > 0 || u.OutputTokens > 0 {
+				final.Usage = u
+			}
+			if len(chunk.Candidates) > 0 && chunk.Candidates[0].FinishReason != "" {
+				final.StopReason = chunk.Candidates[0].FinishReason
+			}
+			msg := chunk.message()
+			if msg.Content != "" && !emit(ctx, ch, StreamEvent{Delta: msg.Content}) {
+				return ctx.Err()
+			}
+			for i := range msg.ToolCalls {
+				if !emit(ctx, ch, StreamEvent{ToolCall: &msg.ToolCalls[i]}) {
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			emit(ctx, ch, StreamEvent{Err: fmt.Errorf("google: %w", err)})
+			return
+		}
+		emit(ctx, ch, final)
+	}()
+	return ch, nil
+}
+
+func (c *Gemini) request(req Request) (geminiRequest, error) {
+	body := geminiRequest{GenerationConfig: c.generationConfig()}
+	var system []geminiPart
+	// functionResponse parts are matched to calls by name, not ID
+	callNames := make(map[string]string)
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, geminiPart{Text: m.Content})
+		case RoleUser:
+			body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: m.Content}}})
+		case RoleAssistant:
+			var parts []geminiPart
+			if m.Content != "" {
+				parts = append(parts, geminiPart{Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				callNames[tc.ID] = tc.Name
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: tc.Name, Args: tc.Arguments}})
+			}
+			body.Contents = append(body.Contents, geminiContent{Role: "model", Parts: parts})
+		case RoleTool:
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     callNames[m.ToolCallID],
+				Response: map[string]interface{}{"content": m.Content},
+			}}
+			// Responses to the calls of one turn go together in one content.
+			if n := len(body.Contents); n > 0 && len(body.Contents[n-1].Parts) > 0 && body.Contents[n-1].Parts[0].FunctionResponse != nil {
+				body.Contents[n-1].Parts = append(body.Contents[n-1].Parts, part)
+			} else {
+				body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+			}
+		default:
+			return body, fmt.Errorf("google: unsupported role %q", m.Role)
+		}
+	}
+	if len(system) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: system}
+	}
+	if len(req.Tools) > 0 {
+		var decls []geminiFunctionDeclaration
+		for _, t := range req.Tools {
+			decl := geminiFunctionDeclaration{Name: t.Name, Description: t.Description, Parameters: t.Parameters}
+			// Gemini rejects OBJECT schemas without properties.
+			if props, _ := t.Parameters["properties"].(map[string]interface{}); len(props) == 0 {
+				decl.Parameters = nil
+			}
+			decls = append(decls, decl)
+		}
+		body.Tools = []geminiTool{{FunctionDeclarations: decls}}
+	}
+	return body, nil
+}
+
+// post calls the given model method and turns non-200 answers into an
+// *APIError.
+func (c *Gemini) post(ctx context.Context, method string, req geminiRequest) (*http.Response, error) {
+	endpoint := fmt.Sprintf("%s/models/%s:%s", c.baseURL, url.PathEscape(c.model), method)
+	resp, err := postJSON(ctx, c.http, endpoint, c.header(), req)
+	if err != nil {
+		return nil, fmt.Errorf("google: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e geminiError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, newAPIError("google", resp, e.Error.Status, e.Error.Message)
+	}
+	return resp, nil
+}
+
+// generationConfig merges the configured options with maxOutputTokens.
+func (c *Gemini) generationConfig() map[string]interface{} {
+	gc := make(map[string]interface{}, len(c.options)+1)
+	for k, v := range c.options {
+		gc[k] = v
+	}
+	if c.maxTokens > 0 {
+		gc["maxOutputTokens"] = c.maxTokens
+	}
+	if len(gc) == 0 {
+		return nil
+	}
+	return gc
+}
+
+func (c *Gemini) header() http.Header {
+	h := http.Header{}
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	} else {
+		h.Set("x-goog-api-key", c.apiKey)
+	}
+	return h
+}
@@ -0,0 +1,161 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+)
+
+func TestGemini_Completion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-1.5-flash:generateContent" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer ya29.test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body struct {
+			SystemInstruction struct {
+				Parts []struct{ Text string } `json:"parts"`
+			} `json:"systemInstruction"`
+			Contents []struct {
+				Role string `json:"role"`
+			} `json:"contents"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.SystemInstruction.Parts) != 1 || body.SystemInstruction.Parts[0].Text != "You are Poe." {
+			t.Errorf("systemInstruction = %+v", body.SystemInstruction)
+		}
+		var roles []string
+		for _, c := range body.Contents {
+			roles = append(roles, c.Role)
+		}
+		if len(roles) != 3 || roles[1] != "model" {
+			t.Errorf("roles = %v, want [user model user]", roles)
+		}
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"At your "},{"text":"service."}]},"finishReason":"STOP"}]}`))
+	}))
+	defer ts.Close()
+
+	c, err := ai.NewGemini(config.LLMConfig{
+		Provider: "google",
+		Model:    "gemini-1.5-flash",
+		Auth:     map[string]*config.Auth{"google": {Strategy: "oauth", Token: "ya29.test", BaseURL: ts.URL}},
+	})
+	if err != nil {
+		t.Fatalf("NewGemini() error = %v", err)
+	}
+	got, err := c.Completion(context.Background(), []ai.Message{
+		{Role: ai.RoleSystem, Content: "You are Poe."},
+		{Role: ai.RoleUser, Content: "Hello"},
+		{Role: ai.RoleAssistant, Content: "Good evening."},
+		{Role: ai.RoleUser, Content: "Status?"},
+	})
+	if err != nil {
+		t.Fatalf("Completion() error = %v", err)
+	}
+	if got != "At your service." {
+		t.Errorf("Completion() = %q", got)
+	}
+}
+
+func TestGemini_Stream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-1.5-pro:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("url = %s", r.URL)
+		}
+		if got := r.Header.Get("x-goog-api-key"); got != "AIza-test" {
+			t.Errorf("x-goog-api-key = %q", got)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Always \"}]}}]}\n\n" +
+			"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"watching.\"}]},\"finishReason\":\"STOP\"}],\"usageMetadata\":{\"promptTokenCount\":4,\"candidatesTokenCount\":2}}\n\n"))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewGemini(config.LLMConfig{Provider: "google", Model: "gemini-1.5-pro",
+		Auth: map[string]*config.Auth{"google": {Strategy: "apikey", APIKey: "AIza-test", BaseURL: ts.URL}}})
+	events, err := c.Stream(context.Background(), ai.Request{Messages: []ai.Message{{Role: ai.RoleUser, Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	text, final, err := ai.Collect(events)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if text != "Always watching." || final.StopReason != "STOP" || final.Usage.InputTokens != 4 {
+		t.Errorf("text = %q, final = %+v", text, final)
+	}
+}
+
+func TestGemini_ChatFunctionCall(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					FunctionResponse *struct {
+						Name string `json:"name"`
+					} `json:"functionResponse"`
+				} `json:"parts"`
+			} `json:"contents"`
+			Tools []struct {
+				FunctionDeclarations []struct {
+					Name string `json:"name"`
+				} `json:"functionDeclarations"`
+			} `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Tools) != 1 || body.Tools[0].FunctionDeclarations[0].Name != "memory_search" {
+			t.Errorf("tools = %+v", body.Tools)
+		}
+		last := body.Contents[len(body.Contents)-1]
+		if fr := last.Parts[0].FunctionResponse; fr == nil || fr.Name != "memory_search" {
+			t.Errorf("last content = %+v, want functionResponse for memory_search", last)
+		}
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"memory_write","args":{"content":"x"}}}]},"finishReason":"STOP"}]}`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewGemini(config.LLMConfig{Provider: "google", Model: "gemini-1.5-pro",
+		Auth: map[string]*config.Auth{"google": {APIKey: "AIza-test", BaseURL: ts.URL}}})
+	resp, err := c.Chat(context.Background(), ai.Request{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Hi"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{{ID: "call_a", Name: "memory_search", Arguments: map[string]interface{}{"query": "x"}}}},
+			{Role: ai.RoleTool, ToolCallID: "call_a", Content: "nothing"},
+		},
+		Tools: []ai.Tool{{Name: "memory_search", Parameters: map[string]interface{}{"type": "object"}}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Name != "memory_write" || resp.Message.ToolCalls[0].ID == "" {
+		t.Errorf("ToolCalls = %+v", resp.Message.ToolCalls)
+	}
+}
+
+func TestGemini_ToolAfterEmptyMessage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewGemini(config.LLMConfig{Provider: "google", Model: "gemini-1.5-pro",
+		Auth: map[string]*config.Auth{"google": {APIKey: "AIza-test", BaseURL: ts.URL}}})
+	_, err := c.Chat(context.Background(), ai.Request{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Hi"},
+			{Role: ai.RoleAssistant},
+			{Role: ai.RoleTool, ToolCallID: "call_a", Content: "nothing"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+}
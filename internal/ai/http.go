@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultHTTPClient is shared by all providers. Long generations can take a
+// while, so the timeout is generous; callers bound requests with ctx.
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// postJSON encodes body as JSON and POSTs it to url. The caller owns the
+// response body.
+func postJSON(ctx context.Context, hc *http.Client, url string, header http.Header, body interface{}) (*http.Response, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return hc.Do(req)
+}
+
+// withOptions returns body with the configured provider options merged in as
+// top-level JSON fields. Options never override fields set by the client.
+func withOptions(body interface{}, opts map[string]interface{}) (interface{}, error) {
+	if len(opts) == 0 {
+		return body, nil
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{})
+	if err := json.Unmarshal(buf, &merged); err != nil {
+		return nil, err
+	}
+	for k, v := range opts {
+		if _, ok := merged[k]; !ok {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func trimBaseURL(u, fallback string) string {
+	if u == "" {
+		u = fallback
+	}
+	return strings.TrimRight(u, "/")
+}
@@ -0,0 +1,340 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// Default endpoints for providers speaking the OpenAI wire format. Other
+// compatible servers (llama.cpp, vLLM) are reached by setting base_url.
+var openAIBaseURLs = map[string]string{
+	"openai": "https://api.openai.com/v1",
+	"ollama": "http://localhost:11434/v1",
+}
+
+// OpenAI is a Client for the OpenAI /v1/chat/completions API and servers
+// that implement the same wire format.
+type OpenAI struct {
+	provider string
+	baseURL  string
+	model    string
+	token    string
+	options  map[string]interface{}
+	http     *http.Client
+}
+
+// NewOpenAI creates an OpenAI-compatible client for cfg.Provider. Credentials,
+// base URL and extra request options are taken from cfg.Auth[cfg.Provider].
+// Only the hosted OpenAI API requires a key; local servers may run without one.
+func NewOpenAI(cfg config.LLMConfig) (Client, error) {
+	provider := cfg.Provider
+	if provider == "" {
+		provider = "openai"
+	}
+	c := &OpenAI{
+		provider: provider,
+		baseURL:  trimBaseURL("", openAIBaseURLs[provider]),
+		model:    cfg.Model,
+		http:     defaultHTTPClient,
+	}
+	if auth := cfg.Auth[provider]; auth != nil {
+		c.baseURL = trimBaseURL(auth.BaseURL, c.baseURL)
+		c.options = auth.Options
+		var err error
+		switch auth.Strategy {
+		case "apikey", "":
+			// Local servers may run without a key.
+			if auth.APIKey != "" || provider == "openai" {
+				c.token, err = credential(provider, "api_key", auth.APIKey)
+			}
+		case "oauth", "token":
+			c.token, err = credential(provider, "token", auth.Token)
+		case "none":
+		default:
+			return nil, fmt.Errorf("%s: unsupported auth strategy %q", provider, auth.Strategy)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.baseURL == "" {
+		return nil, fmt.Errorf("%s: base_url is required", provider)
+	}
+	if provider == "openai" && c.token == "" {
+		return nil, fmt.Errorf("openai: api_key is empty")
+	}
+	return c, nil
+}
+
+func (c *OpenAI) Name() string { return c.provider }
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index,omitempty"` // only set in stream deltas
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"` // JSON encoded
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Tools         []openAITool         `json:"tools,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage openAIUsage `json:"usage"`
+}
+
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+func (tc openAIToolCall) toolCall() (ToolCall, error) {
+	call := ToolCall{ID: tc.ID, Name: tc.Function.Name}
+	if tc.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &call.Arguments); err != nil {
+			return call, fmt.Errorf("decode arguments for %s: %w", tc.Function.Name, err)
+		}
+	}
+	return call, nil
+}
+
+// openAIError covers both OpenAI's {"error": {...}} and the plain
+// {"error": "..."} body returned by some compatible servers.
+type openAIError struct {
+	Error json.RawMessage `json:"error"`
+}
+
+func (e openAIError) decode() (typ, msg string) {
+	var obj struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(e.Error, &obj) == nil {
+		return obj.Type, obj.Message
+	}
+	json.Unmarshal(e.Error, &msg)
+	return "", msg
+}
+
+// Completion sends the conversation to /chat/completions and returns the text
+// of the first choice.
+func (c *OpenAI) Completion(ctx context.Context, messages []Message) (string, error) {
+	resp, err := c.Chat(ctx, Request{Messages: messages})
+	return resp.Message.Content, err
+}
+
+// Chat sends req to /chat/completions and returns the first choice. Tools are
+// declared as functions.
+func (c *OpenAI) Chat(ctx context.Context, req Request) (Response, error) {
+	resp, err := c.post(ctx, c.request(req))
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var out openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Response{}, fmt.Errorf("%s: decode response: %w", c.provider, err)
+	}
+	if len(out.Choices) == 0 {
+		return Response{}, fmt.Errorf("%s: response has no choices", c.provider)
+	}
+	choice := out.Choices[0]
+	msg := Message{Role: RoleAssistant, Content: choice.Message.Content}
+	for _, tc := range choice.Message.ToolCalls {
+		call, err := tc.toolCall()
+		if err != nil {
+			return Response{}, fmt.Errorf("%s: %w", c.provider, err)
+		}
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	return Response{
+		Message:    msg,
+		StopReason: choice.FinishReason,
+		Usage:      Usage{InputTokens: out.Usage.PromptTokens, OutputTokens: out.Usage.CompletionTokens},
+	}, nil
+}
+
+// Stream requests a streamed completion and translates the data-only SSE
+// chunks into StreamEvents. Tool call fragments are assembled by index and
+// emitted when the choice finishes. Usage is reported when the server
+// honours stream_options.include_usage.
+func (c *OpenAI) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	body := c.request(req)
+	body.Stream = true
+	body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	resp, err := c.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		final := StreamEvent{Done: true}
+		var pending []openAIToolCall
+		flush := func() error {
+			for _, tc := range pending {
+				call, err := tc.toolCall()
+				if err != nil {
+					return err
+				}
+				if !emit(ctx, ch, StreamEvent{ToolCall: &call}) {
+					return ctx.Err()
+				}
+			}
+			pending = nil
+			return nil
+		}
+
+		err := readSSE(resp.Body, func(_, data string) error {
+			if data == "[DONE]" {
+				return io.EOF
+			}
+			var chunk openAIChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return fmt.Errorf("decode chunk: %w", err)
+			}
+			if chunk.Usage != nil {
+				final.Usage = Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+			}
+			if len(chunk.Choices) == 0 {
+				return nil
+			}
+			choice := chunk.Choices[0]
+			if choice.Delta.Content != "" && !emit(ctx, ch, StreamEvent{Delta: choice.Delta.Content}) {
+				return ctx.Err()
+			}
+			for _, frag := range choice.Delta.ToolCalls {
+				for len(pending) <= frag.Index {
+					pending = append(pending, openAIToolCall{})
+				}
+				tc := &pending[frag.Index]
+				if frag.ID != "" {
+					tc.ID = frag.ID
+				}
+				tc.Function.Name += frag.Function.Name
+				tc.Function.Arguments += frag.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				final.StopReason = *choice.FinishReason
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			emit(ctx, ch, StreamEvent{Err: fmt.Errorf("%s: %w", c.provider, err)})
+			return
+		}
+		emit(ctx, ch, final)
+	}()
+	return ch, nil
+}
+
+func (c *OpenAI) request(req Request) openAIRequest {
+	body := openAIRequest{Model: c.model}
+	for _, m := range req.Messages {
+		msg := openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, tc := range m.ToolCalls {
+			args := []byte("{}")
+			if tc.Arguments != nil {
+				args, _ = json.Marshal(tc.Arguments)
+			}
+			call := openAIToolCall{ID: tc.ID, Type: "function"}
+			call.Function.Name = tc.Name
+			call.Function.Arguments = string(args)
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	return body
+}
+
+// post sends req with the configured options merged in and turns non-200
+// answers into an *APIError.
+func (c *OpenAI) post(ctx context.Context, req openAIRequest) (*http.Response, error) {
+	body, err := withOptions(req, c.options)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.provider, err)
+	}
+	resp, err := postJSON(ctx, c.http, c.baseURL+"/chat/completions", c.header(), body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e openAIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		typ, msg := e.decode()
+		return nil, newAPIError(c.provider, resp, typ, msg)
+	}
+	return resp, nil
+}
+
+func (c *OpenAI) header() http.Header {
+	h := http.Header{}
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+	return h
+}
@@ -0,0 +1,153 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+)
+
+func TestOpenAI_OllamaCompletion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s, want /v1/chat/completions", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization = %q, want none for ollama", got)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["model"] != "llama3" || body["temperature"] != 0.2 {
+			t.Errorf("body = %v", body)
+		}
+		if msgs, _ := body["messages"].([]interface{}); len(msgs) != 2 {
+			t.Errorf("messages = %v, want 2", body["messages"])
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Hello, fjrt."},"finish_reason":"stop"}]}`))
+	}))
+	defer ts.Close()
+
+	c, err := ai.NewOpenAI(config.LLMConfig{
+		Provider: "ollama",
+		Model:    "llama3",
+		Auth: map[string]*config.Auth{
+			"ollama": {Strategy: "none", BaseURL: ts.URL + "/v1", Options: map[string]interface{}{"temperature": 0.2}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewOpenAI() error = %v", err)
+	}
+	got, err := c.Completion(context.Background(), []ai.Message{
+		{Role: ai.RoleSystem, Content: "You are Poe."},
+		{Role: ai.RoleUser, Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("Completion() error = %v", err)
+	}
+	if got != "Hello, fjrt." {
+		t.Errorf("Completion() = %q", got)
+	}
+	if c.Name() != "ollama" {
+		t.Errorf("Name() = %q, want ollama", c.Name())
+	}
+}
+
+func TestOpenAI_RateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"Rate limit reached","type":"requests"}}`))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewOpenAI(config.LLMConfig{
+		Provider: "openai",
+		Model:    "gpt-4o",
+		Auth:     map[string]*config.Auth{"openai": {Strategy: "apikey", APIKey: "sk-test", BaseURL: ts.URL}},
+	})
+	_, err := c.Completion(context.Background(), []ai.Message{{Role: ai.RoleUser, Content: "Hello"}})
+	if !errors.Is(err, ai.ErrRateLimited) {
+		t.Errorf("Completion() error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestOpenAI_Stream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"},\"finish_reason\":null}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"lo.\"},\"finish_reason\":\"stop\"}]}\n\n" +
+			"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewOpenAI(config.LLMConfig{Provider: "ollama", Model: "llama3",
+		Auth: map[string]*config.Auth{"ollama": {Strategy: "none", BaseURL: ts.URL}}})
+	events, err := c.Stream(context.Background(), ai.Request{Messages: []ai.Message{{Role: ai.RoleUser, Content: "Hi"}}})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	text, final, err := ai.Collect(events)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if text != "Hello." || final.StopReason != "stop" || final.Usage.OutputTokens != 2 {
+		t.Errorf("text = %q, final = %+v", text, final)
+	}
+}
+
+func TestOpenAI_StreamToolCalls(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Tools []struct {
+				Type     string `json:"type"`
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Tools) != 1 || body.Tools[0].Type != "function" || body.Tools[0].Function.Name != "memory_write" {
+			t.Errorf("tools = %+v", body.Tools)
+		}
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"memory_write\",\"arguments\":\"\"}}]}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"content\\\":\"}}]}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"likes rg\\\"}\"}}]}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer ts.Close()
+
+	c, _ := ai.NewOpenAI(config.LLMConfig{Provider: "ollama", Model: "llama3",
+		Auth: map[string]*config.Auth{"ollama": {Strategy: "none", BaseURL: ts.URL}}})
+	events, err := c.Stream(context.Background(), ai.Request{
+		Messages: []ai.Message{{Role: ai.RoleUser, Content: "Remember I like rg"}},
+		Tools:    []ai.Tool{{Name: "memory_write", Parameters: map[string]interface{}{"type": "object"}}},
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	resp, err := ai.CollectResponse(events)
+	if err != nil {
+		t.Fatalf("CollectResponse() error = %v", err)
+	}
+	if resp.StopReason != "tool_calls" || len(resp.Message.ToolCalls) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	call := resp.Message.ToolCalls[0]
+	if call.ID != "call_1" || call.Name != "memory_write" || call.Arguments["content"] != "likes rg" {
+		t.Errorf("tool call = %+v", call)
+	}
+}
@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// Factory builds a Client from the LLM configuration.
+type Factory func(cfg config.LLMConfig) (Client, error)
+
+type registration struct {
+	provider Provider
+	factory  Factory
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []registration
+)
+
+// Register makes a provider available to NewClient and GetProviders under
+// p.ID, and to config.Validate. It panics if the ID is already registered.
+func Register(p Provider, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.provider.ID == p.ID {
+			panic(fmt.Sprintf("ai: provider %q registered twice", p.ID))
+		}
+	}
+	registry = append(registry, registration{provider: p, factory: f})
+	config.RegisterProvider(p.ID)
+}
+
+// GetProviders returns the registered providers in registration order.
+func GetProviders() []Provider {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]Provider, len(registry))
+	for i, r := range registry {
+		out[i] = r.provider
+	}
+	return out
+}
+
+// LookupProvider returns the registered provider with the given ID.
+func LookupProvider(id string) (Provider, bool) {
+	r, ok := lookup(id)
+	return r.provider, ok
+}
+
+func lookup(id string) (registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, r := range registry {
+		if r.provider.ID == id {
+			return r, true
+		}
+	}
+	return registration{}, false
+}
+
+// NewClient validates cfg against the registry and builds a client for
+// cfg.Provider.
+func NewClient(cfg config.LLMConfig) (Client, error) {
+	r, ok := lookup(cfg.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+	if err := validateModel(r.provider, cfg.Model); err != nil {
+		return nil, err
+	}
+	if err := checkCredentials(r.provider, cfg); err != nil {
+		return nil, err
+	}
+	return r.factory(cfg)
+}
+
+func validateModel(p Provider, model string) error {
+	if model == "" {
+		return fmt.Errorf("%s: no model configured", p.ID)
+	}
+	if p.Local {
+		return nil
+	}
+	for _, m := range p.Models {
+		if m == model {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: unknown model %q (available: %v)", p.ID, model, p.Models)
+}
+
+func checkCredentials(p Provider, cfg config.LLMConfig) error {
+	if p.Local {
+		return nil
+	}
+	auth := cfg.Auth[p.ID]
+	if auth == nil {
+		return fmt.Errorf("%w: no [llm.auth.%s] section in config", ErrMissingCredentials, p.ID)
+	}
+	switch auth.Strategy {
+	case "apikey", "":
+		if auth.APIKey == "" {
+			return fmt.Errorf("%w: %s uses strategy \"apikey\" but api_key is empty", ErrMissingCredentials, p.ID)
+		}
+	case "oauth", "token":
+		if auth.Token == "" {
+			return fmt.Errorf("%w: %s uses strategy %q but token is empty", ErrMissingCredentials, p.ID, auth.Strategy)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported auth strategy %q", p.ID, auth.Strategy)
+	}
+	return nil
+}
+
+// credential resolves the secret reference in the named auth field of
+// provider, which must not be empty.
+func credential(provider, field, v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("%s: %s is empty", provider, field)
+	}
+	val, err := config.ResolveSecret(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s: %w", provider, field, err)
+	}
+	if val == "" {
+		return "", fmt.Errorf("%s: %s %q resolved to an empty value", provider, field, v)
+	}
+	return val, nil
+}
@@ -0,0 +1,95 @@
+package ai_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+)
+
+func TestGetProviders_Order(t *testing.T) {
+	var ids []string
+	for _, p := range ai.GetProviders() {
+		ids = append(ids, p.ID)
+	}
+	want := []string{"anthropic", "google", "openai", "ollama"}
+	if len(ids) != len(want) {
+		t.Fatalf("GetProviders() = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("GetProviders()[%d] = %q, want %q", i, ids[i], want[i])
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("POE_TEST_ANTHROPIC_KEY", "sk-from-env")
+	tests := []struct {
+		name    string
+		cfg     config.LLMConfig
+		wantErr bool
+		missing bool
+	}{
+		{
+			name: "anthropic with key",
+			cfg: config.LLMConfig{Provider: "anthropic", Model: "claude-opus-4-6",
+				Auth: map[string]*config.Auth{"anthropic": {Strategy: "apikey", APIKey: "sk-test"}}},
+		},
+		{
+			name: "anthropic with key from the environment",
+			cfg: config.LLMConfig{Provider: "anthropic", Model: "claude-opus-4-6",
+				Auth: map[string]*config.Auth{"anthropic": {Strategy: "apikey", APIKey: "env:POE_TEST_ANTHROPIC_KEY"}}},
+		},
+		{
+			name: "anthropic key from an unset variable",
+			cfg: config.LLMConfig{Provider: "anthropic", Model: "claude-opus-4-6",
+				Auth: map[string]*config.Auth{"anthropic": {Strategy: "apikey", APIKey: "env:POE_TEST_UNSET"}}},
+			wantErr: true,
+		},
+		{
+			name: "anthropic missing key",
+			cfg: config.LLMConfig{Provider: "anthropic", Model: "claude-opus-4-6",
+				Auth: map[string]*config.Auth{"anthropic": {Strategy: "apikey"}}},
+			wantErr: true,
+			missing: true,
+		},
+		{
+			name: "google oauth missing token",
+			cfg: config.LLMConfig{Provider: "google", Model: "gemini-1.5-pro",
+				Auth: map[string]*config.Auth{"google": {Strategy: "oauth", APIKey: "unused"}}},
+			wantErr: true,
+			missing: true,
+		},
+		{
+			name: "model from another provider",
+			cfg: config.LLMConfig{Provider: "openai", Model: "claude-opus-4-6",
+				Auth: map[string]*config.Auth{"openai": {APIKey: "sk-test"}}},
+			wantErr: true,
+		},
+		{
+			name: "ollama accepts any local model",
+			cfg:  config.LLMConfig{Provider: "ollama", Model: "qwen2.5-coder:7b"},
+		},
+		{
+			name:    "unknown provider",
+			cfg:     config.LLMConfig{Provider: "skynet", Model: "t800"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ai.NewClient(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.missing && !errors.Is(err, ai.ErrMissingCredentials) {
+				t.Errorf("NewClient() error = %v, want ErrMissingCredentials", err)
+			}
+			if err == nil && c.Name() != tt.cfg.Provider {
+				t.Errorf("Name() = %q, want %q", c.Name(), tt.cfg.Provider)
+			}
+		})
+	}
+}
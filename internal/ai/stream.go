@@ -0,0 +1,92 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// Collect drains a stream and returns the concatenated text and the final
+// event.
+func Collect(events <-chan StreamEvent) (string, StreamEvent, error) {
+	resp, err := CollectResponse(events)
+	return resp.Message.Content, StreamEvent{Done: true, StopReason: resp.StopReason, Usage: resp.Usage}, err
+}
+
+// CollectResponse drains a stream into the Response Chat would have returned.
+func CollectResponse(events <-chan StreamEvent) (Response, error) {
+	var sb strings.Builder
+	resp := Response{Message: Message{Role: RoleAssistant}}
+	for ev := range events {
+		if ev.Err != nil {
+			resp.Message.Content = sb.String()
+			return resp, ev.Err
+		}
+		sb.WriteString(ev.Delta)
+		if ev.ToolCall != nil {
+			resp.Message.ToolCalls = append(resp.Message.ToolCalls, *ev.ToolCall)
+		}
+		if ev.Done {
+			resp.StopReason, resp.Usage = ev.StopReason, ev.Usage
+		}
+	}
+	resp.Message.Content = sb.String()
+	return resp, nil
+}
+
+// emit delivers ev unless ctx is done first.
+func emit(ctx context.Context, ch chan<- StreamEvent, ev StreamEvent) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// readSSE parses a text/event-stream body and calls fn for each event.
+// Returning io.EOF from fn stops reading without error.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := fn(event, strings.Join(data, "\n"))
+		event, data = "", data[:0]
+		return err
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return ignoreEOF(err)
+			}
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return ignoreEOF(dispatch())
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
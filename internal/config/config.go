@@ -1,51 +1,230 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/fjrt/poeai/internal/crypt"
 )
 
 // Config is the top-level Poe configuration.
 type Config struct {
-	LLM     LLMConfig             `toml:"llm"`
-	Gateway GatewayConfig         `toml:"gateway"`
-	Memory  MemoryConfig          `toml:"memory"`
-	Nodes   map[string]NodeConfig `toml:"nodes"`
+	LLM        LLMConfig             `toml:"llm"`
+	Gateway    GatewayConfig         `toml:"gateway"`
+	Memory     MemoryConfig          `toml:"memory"`
+	Nodes      map[string]NodeConfig `toml:"nodes"`
+	Node       NodeAgentConfig       `toml:"node"`
+	Encryption EncryptionConfig      `toml:"encryption,omitempty"`
+
+	cipher  *crypt.Cipher     // set by Unlock
+	source  *source           // the file Load read, for Validate
+	origins map[string]string // keys overridden by environment or flags
 }
 
 // LLMConfig configures the language model backend.
 type LLMConfig struct {
-	Provider string           `toml:"provider"`
-	Model    string           `toml:"model"`
-	Auth     map[string]*Auth `toml:"auth"`
+	Provider  string           `toml:"provider"`
+	Model     string           `toml:"model"`
+	MaxTokens int              `toml:"max_tokens"`
+	Auth      map[string]*Auth `toml:"auth"`
 }
 
 // Auth defines the authentication strategy for a specific provider.
 type Auth struct {
 	Strategy string `toml:"strategy"` // "apikey" or "oauth"
 	APIKey   string `toml:"api_key"`
-	Token    string `toml:"token"` // OAuth token or comparable mechanism
+	Token    string `toml:"token"`              // OAuth token or comparable mechanism
+	BaseURL  string `toml:"base_url,omitempty"` // overrides the provider's default API endpoint
+
+	// Options are extra provider specific request fields, e.g. temperature.
+	Options map[string]interface{} `toml:"options,omitempty"`
 }
 
 // GatewayConfig configures the gateway daemon.
 type GatewayConfig struct {
 	Socket string `toml:"socket"`
 	Port   int    `toml:"port"`
+	Host   string `toml:"host"`   // where poe finds the gateway
+	Listen string `toml:"listen"` // address the gateway listens on; loopback only by default
+
+	// Token authenticates clients that are not on the gateway's machine. It
+	// is required when Listen is not a loopback address, and may be a
+	// secret reference.
+	Token string `toml:"token,omitempty"`
+}
+
+// IsLoopback reports whether host names this machine: localhost or a
+// loopback address.
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// Addr returns the host:port clients connect to.
+func (g GatewayConfig) Addr() string {
+	return net.JoinHostPort(g.Host, strconv.Itoa(g.Port))
 }
 
 // MemoryConfig configures the memory backend.
 type MemoryConfig struct {
 	DBPath         string `toml:"db_path"`
-	EmbeddingModel string `toml:"embedding_model"`
+	EmbeddingModel string `toml:"embedding_model"`         // "provider/model"; empty disables semantic search
+	VecExtension   string `toml:"vec_extension,omitempty"` // path to the sqlite-vec extension, optional
+	WorkingMemory  int    `toml:"working_memory"`          // recent messages sent to the model each turn
+	ConsolidateAt  string `toml:"consolidate_at"`          // local "15:04" time of nightly consolidation; empty disables it
+
+	// MinSimilarity is the cosine similarity, 0..1, a memory needs to a
+	// query to be found by semantic search; 0 means the store's default.
+	MinSimilarity float64 `toml:"min_similarity"`
+
+	// Importance of unaccessed memories halves every half-life, per memory
+	// type; 0 means the type never decays. Memories below ArchiveBelow are
+	// archived and kept for ArchiveDays (0 keeps them forever).
+	HalfLifeDays map[string]float64 `toml:"half_life_days"`
+	ArchiveBelow float64            `toml:"archive_below"`
+	ArchiveDays  int                `toml:"archive_days"`
+}
+
+// NodeAgentConfig configures poe-node, the companion agent on a phone.
+type NodeAgentConfig struct {
+	Listen string `toml:"listen"`
 }
 
 // NodeConfig configures an SSH-accessible homelab node.
 type NodeConfig struct {
-	Host string `toml:"host"`
+	Host string `toml:"host"` // "name" or "name:port"; the port defaults to 22
 	User string `toml:"user"`
-	Key  string `toml:"key"`
+	Key  string `toml:"key"` // private key; empty to use only the agent
+
+	// Agent authenticates with the keys in the ssh-agent at $SSH_AUTH_SOCK,
+	// before Key. It is implied when Key is empty.
+	Agent bool `toml:"agent,omitempty"`
+	// Passphrase unlocks Key. It may be a secret reference, see
+	// ResolveSecret; if empty, interactive commands ask for it.
+	Passphrase string `toml:"passphrase,omitempty"`
+	// Certificate is the OpenSSH user certificate for Key, signed by a CA
+	// the node trusts. It defaults to Key with "-cert.pub" appended, if
+	// that file exists.
+	Certificate string `toml:"certificate,omitempty"`
+
+	// Allow and Deny limit the commands Poe's tools may run on the node.
+	// Patterns match word by word: * matches within a word, and a final *
+	// on its own any further arguments, e.g. "systemctl status *". A deny
+	// pattern refuses every command starting with it and wins over Allow;
+	// with Allow empty, every command not denied is allowed. Deny lists
+	// are best-effort, as a command can be run in many ways; only Allow
+	// limits what runs. Reading a file counts as the command "cat <path>",
+	// and node status is always allowed.
+	Allow []string `toml:"allow,omitempty"`
+	Deny  []string `toml:"deny,omitempty"`
+}
+
+// EncryptionConfig records that memories, sessions and credentials are
+// encrypted at rest, and how to derive the key. It is written by poe rekey.
+type EncryptionConfig struct {
+	KeyFile string `toml:"key_file,omitempty"` // secret to derive the key from; otherwise a passphrase
+	Salt    string `toml:"salt,omitempty"`
+	Check   string `toml:"check,omitempty"`
+}
+
+// PassphraseEnv names the environment variable Secret reads the passphrase
+// from, for running the gateway unattended without a key file.
+const PassphraseEnv = "POE_PASSPHRASE"
+
+// ErrNoPassphrase is returned by Secret when encryption uses a passphrase
+// and none is set in the environment; interactive commands prompt for it.
+var ErrNoPassphrase = errors.New("encryption passphrase required (set " + PassphraseEnv + " or use a key file)")
+
+// ErrLocked is returned by Save for an encrypted configuration with new
+// credentials that cannot be encrypted because it was not unlocked.
+var ErrLocked = errors.New("configuration is encrypted and was not unlocked")
+
+// Enabled reports whether encryption is on.
+func (e EncryptionConfig) Enabled() bool {
+	return e.Salt != ""
+}
+
+func (e EncryptionConfig) params() crypt.Params {
+	return crypt.Params{Salt: e.Salt, Check: e.Check}
+}
+
+// Secret returns the secret the key is derived from: the contents of the
+// key file, without a trailing newline, or the passphrase in $POE_PASSPHRASE.
+func (e EncryptionConfig) Secret() ([]byte, error) {
+	if e.KeyFile != "" {
+		buf, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("key file: %w", err)
+		}
+		return bytes.TrimRight(buf, "\r\n"), nil
+	}
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	return nil, ErrNoPassphrase
+}
+
+// Unlock derives the key from secret and decrypts the credentials in
+// c.LLM.Auth, the gateway token and node passphrases. It does nothing if
+// encryption is off.
+func (c *Config) Unlock(secret []byte) error {
+	if !c.Encryption.Enabled() {
+		return nil
+	}
+	cipher, err := crypt.Unlock(secret, c.Encryption.params())
+	if err != nil {
+		return err
+	}
+	for name, a := range c.LLM.Auth {
+		if a == nil {
+			continue
+		}
+		if a.APIKey, err = cipher.Open(a.APIKey); err != nil {
+			return fmt.Errorf("auth %s: api_key: %w", name, err)
+		}
+		if a.Token, err = cipher.Open(a.Token); err != nil {
+			return fmt.Errorf("auth %s: token: %w", name, err)
+		}
+	}
+	if c.Gateway.Token, err = cipher.Open(c.Gateway.Token); err != nil {
+		return fmt.Errorf("gateway: token: %w", err)
+	}
+	for name, n := range c.Nodes {
+		if n.Passphrase, err = cipher.Open(n.Passphrase); err != nil {
+			return fmt.Errorf("nodes %s: passphrase: %w", name, err)
+		}
+		c.Nodes[name] = n
+	}
+	c.cipher = cipher
+	return nil
+}
+
+// Cipher returns the key set by Unlock or SetKey, or nil if encryption is
+// off.
+func (c Config) Cipher() *crypt.Cipher {
+	return c.cipher
+}
+
+// SetKey switches encryption to a new key, derived with p from the secret in
+// keyFile or from a passphrase when keyFile is empty. A nil cipher turns
+// encryption off. The credentials are encrypted with the new key on Save.
+func (c *Config) SetKey(cipher *crypt.Cipher, p crypt.Params, keyFile string) {
+	c.cipher = cipher
+	c.Encryption = EncryptionConfig{}
+	if cipher != nil {
+		c.Encryption = EncryptionConfig{KeyFile: keyFile, Salt: p.Salt, Check: p.Check}
+	}
 }
 
 func defaults() Config {
@@ -56,46 +235,180 @@ func defaults() Config {
 
 	return Config{
 		LLM: LLMConfig{
-			Provider: "anthropic",
-			Model:    "claude-opus-4-6",
-			Auth:     authMap,
+			Provider:  "anthropic",
+			Model:     "claude-opus-4-6",
+			MaxTokens: 4096,
+			Auth:      authMap,
 		},
 		Gateway: GatewayConfig{
 			Socket: filepath.Join(home, ".poe", "poe.sock"),
 			Port:   7331,
+			Host:   "localhost",
+			Listen: "127.0.0.1",
 		},
 		Memory: MemoryConfig{
 			DBPath:         filepath.Join(home, ".poe", "poe.db"),
 			EmbeddingModel: "ollama/nomic-embed-text",
+			WorkingMemory:  20,
+			ConsolidateAt:  "03:00",
+			MinSimilarity:  0.45,
+			HalfLifeDays: map[string]float64{
+				"episodic":   30,
+				"semantic":   180,
+				"procedural": 365,
+				"fact":       0,
+			},
+			ArchiveBelow: 0.05,
+			ArchiveDays:  365,
 		},
 		Nodes: make(map[string]NodeConfig),
+		Node:  NodeAgentConfig{Listen: ":7332"},
 	}
 }
 
-// Load loads configuration from the given TOML file path.
-// If path is empty, returns defaults.
+// Load loads configuration from the given TOML file path over the defaults,
+// and expands ~ and environment variables in paths. If path is empty,
+// returns defaults. Load does not check the values; see Validate.
 func Load(path string) (Config, error) {
 	cfg := defaults()
 	if path == "" {
 		return cfg, nil
 	}
-	_, err := toml.DecodeFile(path, &cfg)
-	return cfg, err
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.source = &source{path: path, lines: keyLines(string(data)), undecoded: md.Undecoded(), paths: make(map[string]string)}
+	cfg.eachPath(func(key string, p *string) {
+		if ExpandPath(*p) != *p {
+			cfg.source.paths[key] = *p
+		}
+	})
+	cfg.expandPaths()
+	return cfg, nil
+}
+
+// expandPaths expands a leading ~ and environment variables in the path
+// settings.
+func (c *Config) expandPaths() {
+	c.eachPath(func(_ string, p *string) { *p = ExpandPath(*p) })
+}
+
+// eachPath calls f with the dotted key and value of each path setting.
+func (c *Config) eachPath(f func(key string, p *string)) {
+	f("gateway.socket", &c.Gateway.Socket)
+	f("memory.db_path", &c.Memory.DBPath)
+	f("memory.vec_extension", &c.Memory.VecExtension)
+	f("encryption.key_file", &c.Encryption.KeyFile)
+	for name, n := range c.Nodes {
+		f("nodes."+name+".key", &n.Key)
+		f("nodes."+name+".certificate", &n.Certificate)
+		c.Nodes[name] = n
+	}
+}
+
+// ExpandPath replaces a leading ~ with the home directory and expands $VAR
+// and ${VAR} references.
+func ExpandPath(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		p = home + p[1:]
+	}
+	return os.ExpandEnv(p)
 }
 
-// Save saves the current configuration to the given path.
+// Save saves the current configuration to the given path. The file holds
+// credentials, so it is readable by the owner only and replaced atomically.
+// With encryption on, the credentials are encrypted. Paths that Load
+// expanded are written as they were in the file unless they were changed.
 func (c Config) Save(path string) error {
+	if len(c.origins) > 0 {
+		return errors.New("configuration has environment or flag overrides; refusing to write them to the file")
+	}
+	if c.source != nil && len(c.source.paths) > 0 {
+		c.Nodes = maps.Clone(c.Nodes)
+		c.eachPath(func(key string, p *string) {
+			if raw, ok := c.source.paths[key]; ok && ExpandPath(raw) == *p {
+				*p = raw
+			}
+		})
+	}
+	if c.Encryption.Enabled() {
+		sealed, err := c.sealAuth()
+		if err != nil {
+			return err
+		}
+		c.LLM.Auth = sealed
+		if c.Gateway.Token, err = c.seal(c.Gateway.Token); err != nil {
+			return fmt.Errorf("gateway: %w", err)
+		}
+		nodes := make(map[string]NodeConfig, len(c.Nodes))
+		for name, n := range c.Nodes {
+			if n.Passphrase, err = c.seal(n.Passphrase); err != nil {
+				return fmt.Errorf("nodes %s: %w", name, err)
+			}
+			nodes[name] = n
+		}
+		c.Nodes = nodes
+	}
+
 	// Ensure directory exists
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	f, err := os.Create(path)
+	f, err := os.CreateTemp(dir, ".config-*.toml")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
+	if err := toml.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// sealAuth returns a copy of c.LLM.Auth with the credentials encrypted.
+func (c Config) sealAuth() (map[string]*Auth, error) {
+	out := make(map[string]*Auth, len(c.LLM.Auth))
+	for name, a := range c.LLM.Auth {
+		if a == nil {
+			continue
+		}
+		cp := *a
+		var err error
+		if cp.APIKey, err = c.seal(a.APIKey); err != nil {
+			return nil, fmt.Errorf("auth %s: %w", name, err)
+		}
+		if cp.Token, err = c.seal(a.Token); err != nil {
+			return nil, fmt.Errorf("auth %s: %w", name, err)
+		}
+		out[name] = &cp
+	}
+	return out, nil
+}
 
-	return toml.NewEncoder(f).Encode(c)
+// seal encrypts a credential. Credentials still encrypted from Load are kept
+// as they are.
+func (c Config) seal(v string) (string, error) {
+	switch {
+	case v == "" || crypt.IsSealed(v) && c.cipher == nil:
+		return v, nil
+	case c.cipher == nil:
+		return "", ErrLocked
+	}
+	plain, err := c.cipher.Open(v)
+	if err != nil {
+		return "", err
+	}
+	return c.cipher.Seal(plain), nil
 }
@@ -1,9 +1,14 @@
 package config_test
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
 	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/crypt"
 )
 
 func TestLoadConfig_Defaults(t *testing.T) {
@@ -33,3 +38,279 @@ func TestLoadConfig_FromFile(t *testing.T) {
 		t.Errorf("port = %d, want 9999", cfg.Gateway.Port)
 	}
 }
+
+func TestLoadConfig_MergesHalfLives(t *testing.T) {
+	f, _ := os.CreateTemp("", "poe-config-*.toml")
+	f.WriteString("[memory.half_life_days]\nepisodic = 7\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Memory.HalfLifeDays["episodic"] != 7 || cfg.Memory.HalfLifeDays["semantic"] != 180 {
+		t.Errorf("half lives = %v, want episodic overridden and defaults kept", cfg.Memory.HalfLifeDays)
+	}
+}
+
+func TestSave_EncryptsCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	cfg, _ := config.Load("")
+	cfg.LLM.Auth["anthropic"].APIKey = "sk-ant-secret"
+	cfg.Nodes["nas"] = config.NodeConfig{Host: "nas.lan", Passphrase: "key-passphrase"}
+	key, params, _ := crypt.NewKey([]byte("correct horse"))
+	cfg.SetKey(key, params, "")
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %v, want 0600", info.Mode().Perm())
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "sk-ant-secret") || strings.Contains(string(raw), "key-passphrase") {
+		t.Error("config holds credentials in plaintext")
+	}
+
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := loaded.Unlock([]byte("battery staple")); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("Unlock(wrong passphrase) error = %v, want ErrWrongKey", err)
+	}
+	if err := loaded.Unlock([]byte("correct horse")); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if got := loaded.LLM.Auth["anthropic"].APIKey; got != "sk-ant-secret" {
+		t.Errorf("unlocked API key = %q", got)
+	}
+	if got := loaded.Nodes["nas"].Passphrase; got != "key-passphrase" {
+		t.Errorf("unlocked node passphrase = %q", got)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("POE_TEST_KEY", "sk-env-1234")
+	file := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(file, []byte("sk-file-5678\n"), 0600)
+
+	tests := []struct {
+		ref, want string
+		wantErr   bool
+	}{
+		{ref: "sk-literal-0000", want: "sk-literal-0000"},
+		{ref: "env:POE_TEST_KEY", want: "sk-env-1234"},
+		{ref: "env:POE_TEST_UNSET", wantErr: true},
+		{ref: "file:" + file, want: "sk-file-5678"},
+		{ref: "file:" + file + ".missing", wantErr: true},
+		{ref: "cmd:printf 'sk-cmd-9012\\nsecond line'", want: "sk-cmd-9012"},
+		{ref: "cmd:exit 3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := config.ResolveSecret(tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, %v; want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if got := config.Redact("auth with sk-env-1234 and sk-cmd-9012 failed"); got != "auth with [redacted] and [redacted] failed" {
+		t.Errorf("Redact() = %q", got)
+	}
+	// A short value would blank unrelated text, so it is not redacted.
+	config.ResolveSecret("pi")
+	if got := config.Redact("ping pi.lan"); got != "ping pi.lan" {
+		t.Errorf("Redact() with a short secret = %q, want it unchanged", got)
+	}
+
+	// cmd: references are run once until the cache is cleared.
+	ref := "cmd:cat " + file
+	first, _ := config.ResolveSecret(ref)
+	os.WriteFile(file, []byte("sk-rotated-3456\n"), 0600)
+	if got, _ := config.ResolveSecret(ref); got != first {
+		t.Errorf("ResolveSecret(%q) = %q before ForgetCachedSecrets, want cached %q", ref, got, first)
+	}
+	config.ForgetCachedSecrets()
+	if got, _ := config.ResolveSecret(ref); got != "sk-rotated-3456" {
+		t.Errorf("ResolveSecret(%q) = %q after ForgetCachedSecrets, want the rotated value", ref, got)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg, _ := config.Load("")
+	cfg.LLM.Auth["anthropic"].APIKey = "sk-ant-secret"
+	cfg.LLM.Auth["openai"] = &config.Auth{APIKey: "env:OPENAI_API_KEY"}
+
+	r := cfg.Redacted()
+	if r.LLM.Auth["anthropic"].APIKey != "[redacted]" || r.LLM.Auth["openai"].APIKey != "env:OPENAI_API_KEY" {
+		t.Errorf("Redacted() auth = %+v, %+v; want the literal hidden and the reference kept",
+			r.LLM.Auth["anthropic"], r.LLM.Auth["openai"])
+	}
+	if cfg.LLM.Auth["anthropic"].APIKey != "sk-ant-secret" {
+		t.Error("Redacted() modified the original config")
+	}
+}
+
+func TestLoad_ExpandsPaths(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	t.Setenv("POE_TEST_DIR", "/srv/poe")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte(`
+[gateway]
+socket = "~/.poe/poe.sock"
+
+[memory]
+db_path = "$POE_TEST_DIR/poe.db"
+
+[nodes.nas]
+host = "nas.lan"
+key = "~/.ssh/id_ed25519"
+`), 0600)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := filepath.Join(home, ".poe", "poe.sock"); cfg.Gateway.Socket != want {
+		t.Errorf("socket = %q, want %q", cfg.Gateway.Socket, want)
+	}
+	if cfg.Memory.DBPath != "/srv/poe/poe.db" {
+		t.Errorf("db_path = %q, want /srv/poe/poe.db", cfg.Memory.DBPath)
+	}
+	if want := filepath.Join(home, ".ssh", "id_ed25519"); cfg.Nodes["nas"].Key != want {
+		t.Errorf("node key = %q, want %q", cfg.Nodes["nas"].Key, want)
+	}
+}
+
+func TestSave_KeepsUnexpandedPaths(t *testing.T) {
+	t.Setenv("POE_TEST_DIR", "/srv/poe")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte(`
+[gateway]
+socket = "~/.poe/poe.sock"
+
+[memory]
+db_path = "$POE_TEST_DIR/poe.db"
+
+[nodes.nas]
+host = "nas.lan"
+key = "~/.ssh/id_ed25519"
+`), 0600)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg.Gateway.Socket = "/run/poe.sock" // changed settings are saved as set
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{`socket = "/run/poe.sock"`, `db_path = "$POE_TEST_DIR/poe.db"`, `key = "~/.ssh/id_ed25519"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("saved config lacks %s:\n%s", want, data)
+		}
+	}
+	if cfg.Nodes["nas"].Key == "~/.ssh/id_ed25519" {
+		t.Error("Save() changed the node key of the saved configuration")
+	}
+}
+
+func TestValidate_ReportsAllProblems(t *testing.T) {
+	config.RegisterProvider("anthropic")
+	config.RegisterProvider("ollama")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte(`[llm]
+provider = "skynet"
+
+[gateway]
+prot = 7331
+port = 70000
+listen = "0.0.0.0"
+
+[nodes.nas]
+host = "nas.lan"
+key = "/nonexistent/id_ed25519"
+
+[nodez.pi]
+host = "pi.lan"
+`), 0600)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	err = cfg.Validate()
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want a ValidationError", err)
+	}
+	want := []config.Problem{
+		{Key: "llm.provider", Line: 2},
+		{Key: "gateway.token", Line: 4},
+		{Key: "gateway.prot", Line: 5},
+		{Key: "gateway.port", Line: 6},
+		{Key: "nodes.nas.key", Line: 11},
+		{Key: "nodez.pi", Line: 13},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("Validate() problems:\n%v\nwant %d", err, len(want))
+	}
+	for i, w := range want {
+		if p := verr.Problems[i]; p.Key != w.Key || p.Line != w.Line {
+			t.Errorf("problem %d = %+v, want %s on line %d", i, p, w.Key, w.Line)
+		}
+	}
+
+	if err := (config.Config{}).Validate(); err == nil {
+		t.Error("Validate() of an empty config = nil, want problems")
+	}
+	defaults, _ := config.Load("")
+	if err := defaults.Validate(); err != nil {
+		t.Errorf("Validate() of the defaults = %v", err)
+	}
+}
+
+func TestFlags_Layers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte("[gateway]\nport = 8000\nhost = \"gateway.lan\"\n\n[llm]\nmodel = \"claude-sonnet-4-6\"\n"), 0600)
+	t.Setenv("POE_GATEWAY_PORT", "8100")
+	t.Setenv("POE_LLM_MODEL", "claude-haiku-4-5")
+	t.Setenv("POE_PASSPHRASE", "not a setting")
+
+	flags := config.Flags{Path: path, Set: []string{"llm.model=claude-opus-4-6"}}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Gateway.Addr() != "gateway.lan:8100" || cfg.LLM.Model != "claude-opus-4-6" {
+		t.Errorf("effective config: addr %s, model %s; want env over file and flags over env",
+			cfg.Gateway.Addr(), cfg.LLM.Model)
+	}
+
+	origins := make(map[string]string)
+	for _, s := range cfg.Settings() {
+		origins[s.Key] = s.Origin
+	}
+	want := map[string]string{
+		"gateway.port":   "env POE_GATEWAY_PORT",
+		"gateway.host":   "file " + path + ":3",
+		"llm.model":      "flag --set",
+		"llm.max_tokens": "default",
+	}
+	for k, w := range want {
+		if origins[k] != w {
+			t.Errorf("origin of %s = %q, want %q", k, origins[k], w)
+		}
+	}
+	if err := cfg.Save(path); err == nil {
+		t.Error("Save() of a config with overrides succeeded")
+	}
+
+	bad := config.Flags{Path: path, Set: []string{"gateway.prot=1"}}
+	if _, err := bad.Load(); err == nil {
+		t.Error("Load() with --set of an unknown key succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The effective configuration is built in layers, each overriding the one
+// before: defaults, the config file, POE_* environment variables, and
+// --set flags. Environment variables are named after the setting, e.g.
+// POE_GATEWAY_PORT for gateway.port.
+
+// ConfigEnv names the environment variable that overrides DefaultPath.
+const ConfigEnv = "POE_CONFIG"
+
+const envPrefix = "POE_"
+
+// DefaultPath returns $POE_CONFIG, or ~/.poe/config.toml.
+func DefaultPath() string {
+	if p := os.Getenv(ConfigEnv); p != "" {
+		return ExpandPath(p)
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".poe", "config.toml")
+}
+
+// Flags are the command-line options shared by Poe's commands.
+type Flags struct {
+	Path string   // --config
+	Set  []string // --set key=value, in order
+}
+
+// Register adds --config and --set to fs.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Path, "config", DefaultPath(), "configuration file")
+	fs.Func("set", "override a setting, e.g. --set gateway.port=7332 (repeatable)", func(s string) error {
+		if _, _, ok := strings.Cut(s, "="); !ok {
+			return fmt.Errorf("want key=value")
+		}
+		f.Set = append(f.Set, s)
+		return nil
+	})
+}
+
+// Load builds the effective configuration from all layers. If the file does
+// not exist, the other layers are still applied and the error satisfies
+// os.IsNotExist. The result cannot be saved, as that would write the
+// overrides to the file; edit the result of the package-level Load instead.
+func (f Flags) Load() (Config, error) {
+	cfg, fileErr := Load(f.Path)
+	if fileErr != nil && !os.IsNotExist(fileErr) {
+		return cfg, fileErr
+	}
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		return cfg, err
+	}
+	for _, s := range f.Set {
+		key, value, _ := strings.Cut(s, "=")
+		if err := cfg.Set(key, value, "flag --set"); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.expandPaths()
+	return cfg, fileErr
+}
+
+// ApplyEnv applies the POE_* variables in environ, as returned by
+// os.Environ, that name a setting. Others, like POE_PASSPHRASE, are ignored.
+func (c *Config) ApplyEnv(environ []string) error {
+	byEnv := make(map[string]string)
+	for _, key := range settableKeys() {
+		byEnv[EnvName(key)] = key
+	}
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		key, ok := byEnv[name]
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, value, "env "+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable overriding a setting.
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Set overrides a setting given by its dotted TOML key, such as
+// "gateway.port", parsing value for its type, and records origin as where
+// the value came from. Only single values outside of tables of named entries
+// (auth, nodes) can be set, and not the encryption settings.
+func (c *Config) Set(key, value, origin string) error {
+	field, ok := settable(reflect.ValueOf(c).Elem(), key)
+	if !ok {
+		return fmt.Errorf("%s: unknown setting %q (settable: %s)", origin, key, strings.Join(settableKeys(), ", "))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not an integer", origin, key, value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not a number", origin, key, value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not a boolean", origin, key, value)
+		}
+		field.SetBool(b)
+	}
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	c.origins[key] = origin
+	return nil
+}
+
+// settable returns the scalar field of a Config value for key.
+func settable(v reflect.Value, key string) (reflect.Value, bool) {
+	if strings.HasPrefix(key, "encryption.") {
+		return reflect.Value{}, false
+	}
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		i, ok := fieldByTag(v.Type(), name)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		v = v.Field(i)
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Float64, reflect.Bool:
+		return v, true
+	}
+	return reflect.Value{}, false
+}
+
+// settableKeys lists the keys Set accepts.
+func settableKeys() []string {
+	var keys []string
+	var cfg Config
+	walk(reflect.ValueOf(cfg), "", func(key string, _ reflect.Value) {
+		if _, ok := settable(reflect.ValueOf(&cfg).Elem(), key); ok {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+func fieldByTag(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() && tomlName(f) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func tomlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("toml"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// walk calls fn with the dotted key and value of every single value in v,
+// with map entries in key order.
+func walk(v reflect.Value, prefix string, fn func(key string, v reflect.Value)) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walk(v.Elem(), prefix, fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() || f.Tag.Get("toml") == "-" {
+				continue
+			}
+			walk(v.Field(i), join(tomlName(f)), fn)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			walk(v.MapIndex(k), join(k.String()), fn)
+		}
+	default:
+		fn(prefix, v)
+	}
+}
+
+// Setting is one value of the effective configuration.
+type Setting struct {
+	Key    string // dotted TOML key
+	Value  string // in TOML syntax
+	Origin string // "default", "file <path>:<line>", "env <NAME>" or "flag --set"
+}
+
+// Settings lists every value of c with where it came from, for display.
+// Use it on Redacted to hide credentials.
+func (c Config) Settings() []Setting {
+	var out []Setting
+	walk(reflect.ValueOf(c), "", func(key string, v reflect.Value) {
+		out = append(out, Setting{Key: key, Value: tomlValue(v), Origin: c.origin(key)})
+	})
+	return out
+}
+
+func (c Config) origin(key string) string {
+	if o, ok := c.origins[key]; ok {
+		return o
+	}
+	if c.source != nil {
+		if line, ok := c.source.lines[key]; ok {
+			return fmt.Sprintf("file %s:%d", c.source.path, line)
+		}
+	}
+	return "default"
+}
+
+func tomlValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return `""`
+		}
+		return tomlValue(v.Elem())
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Slice, reflect.Array:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = tomlValue(v.Index(i))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret references let api_key, token, the gateway token and node
+// passphrases name where a credential lives instead of holding it:
+//
+//	env:ANTHROPIC_API_KEY         an environment variable
+//	file:~/.config/poe/openai.key a file, without its trailing newline
+//	cmd:pass show poe/anthropic   the output of a shell command, e.g. a
+//	                              password manager
+//
+// Any other value is the credential itself.
+const (
+	envRef  = "env:"
+	fileRef = "file:"
+	cmdRef  = "cmd:"
+)
+
+// secretCmdTimeout bounds a cmd: reference; password managers may prompt.
+const secretCmdTimeout = time.Minute
+
+// redacted replaces secrets in logs and config dumps.
+const redacted = "[redacted]"
+
+// minRedactLength is the length below which a resolved value is not
+// redacted from logs: a short value, like a PIN or a common word, would
+// blank unrelated text, and real credentials are longer.
+const minRedactLength = 8
+
+var (
+	secretsMu sync.Mutex
+	secrets   = make(map[string]bool)   // resolved values, for Redact
+	cmdCache  = make(map[string]string) // cmd: references already run
+)
+
+// IsSecretRef reports whether v is a secret reference rather than a literal
+// credential.
+func IsSecretRef(v string) bool {
+	return strings.HasPrefix(v, envRef) || strings.HasPrefix(v, fileRef) || strings.HasPrefix(v, cmdRef)
+}
+
+// ResolveSecret returns the credential v refers to, or v itself if it is not
+// a reference. The output of cmd: references is cached until
+// ForgetCachedSecrets so that a password manager is asked only once.
+// Resolved values are redacted by Redact from then on.
+func ResolveSecret(v string) (string, error) {
+	var val string
+	switch {
+	case strings.HasPrefix(v, envRef):
+		name := strings.TrimPrefix(v, envRef)
+		var ok bool
+		if val, ok = os.LookupEnv(name); !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+	case strings.HasPrefix(v, fileRef):
+		buf, err := os.ReadFile(ExpandPath(strings.TrimPrefix(v, fileRef)))
+		if err != nil {
+			return "", fmt.Errorf("secret file: %w", err)
+		}
+		val = strings.TrimRight(string(buf), "\r\n")
+
+	case strings.HasPrefix(v, cmdRef):
+		var err error
+		if val, err = runSecretCmd(strings.TrimPrefix(v, cmdRef)); err != nil {
+			return "", err
+		}
+
+	default:
+		val = v
+	}
+
+	if len(val) >= minRedactLength {
+		secretsMu.Lock()
+		secrets[val] = true
+		secretsMu.Unlock()
+	}
+	return val, nil
+}
+
+func runSecretCmd(command string) (string, error) {
+	secretsMu.Lock()
+	val, ok := cmdCache[command]
+	secretsMu.Unlock()
+	if ok {
+		return val, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretCmdTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Stdin = os.Stdin // for password managers that prompt
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("secret command %q: %w", command, err)
+		}
+		return "", fmt.Errorf("secret command %q: %w: %s", command, err, msg)
+	}
+	// Password managers print the secret on the first line.
+	val, _, _ = strings.Cut(stdout.String(), "\n")
+	val = strings.TrimRight(val, "\r")
+
+	secretsMu.Lock()
+	cmdCache[command] = val
+	secretsMu.Unlock()
+	return val, nil
+}
+
+// ForgetCachedSecrets clears the cached output of cmd: references, so that
+// they are run again, e.g. to pick up rotated credentials when the
+// configuration is reloaded. Values resolved so far are still redacted.
+func ForgetCachedSecrets() {
+	secretsMu.Lock()
+	clear(cmdCache)
+	secretsMu.Unlock()
+}
+
+// Redact replaces every secret resolved so far in s, except values too short
+// to tell apart from other text.
+func Redact(s string) string {
+	secretsMu.Lock()
+	vals := make([]string, 0, len(secrets))
+	for v := range secrets {
+		vals = append(vals, v)
+	}
+	secretsMu.Unlock()
+	// Longest first, so that a secret containing another is replaced whole.
+	sort.Slice(vals, func(i, j int) bool { return len(vals[i]) > len(vals[j]) })
+	for _, v := range vals {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// RedactWriter returns a writer that passes each write through Redact, for
+// use with log.SetOutput. Log writes are whole lines, so secrets are not
+// split across writes.
+func RedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w}
+}
+
+type redactWriter struct{ w io.Writer }
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Redacted returns a copy of c safe to print: literal credentials are
+// replaced, while secret references, which only name where a credential
+// lives, are kept.
+func (c Config) Redacted() Config {
+	hide := func(v string) string {
+		if v == "" || IsSecretRef(v) {
+			return v
+		}
+		return redacted
+	}
+	auth := make(map[string]*Auth, len(c.LLM.Auth))
+	for name, a := range c.LLM.Auth {
+		if a == nil {
+			continue
+		}
+		cp := *a
+		cp.APIKey = hide(a.APIKey)
+		cp.Token = hide(a.Token)
+		auth[name] = &cp
+	}
+	c.LLM.Auth = auth
+	c.Gateway.Token = hide(c.Gateway.Token)
+	nodes := make(map[string]NodeConfig, len(c.Nodes))
+	for name, n := range c.Nodes {
+		n.Passphrase = hide(n.Passphrase)
+		nodes[name] = n
+	}
+	c.Nodes = nodes
+	c.cipher = nil
+	return c
+}
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// source is what Load remembers about the file it read.
+type source struct {
+	path      string
+	lines     map[string]int    // dotted key to the line defining it
+	undecoded []toml.Key        // keys that match no setting
+	paths     map[string]string // dotted key to a path setting as written, before expansion
+}
+
+// Problem is one invalid setting found by Validate.
+type Problem struct {
+	Key  string // dotted TOML key, e.g. "gateway.port"
+	Line int    // line in the config file, 0 if the key is not set there
+	Msg  string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Key, p.Msg)
+	}
+	return p.Key + ": " + p.Msg
+}
+
+// ValidationError lists every problem Validate found.
+type ValidationError struct {
+	Path     string // config file, empty for defaults
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	if e.Path != "" {
+		sb.WriteString(e.Path + ": ")
+	}
+	fmt.Fprintf(&sb, "%d problem(s) in configuration", len(e.Problems))
+	for _, p := range e.Problems {
+		sb.WriteString("\n  " + p.String())
+	}
+	return sb.String()
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]bool)
+)
+
+// RegisterProvider makes Validate accept id as an LLM provider. The ai
+// package registers each of its providers; until one is registered, any
+// provider is accepted.
+func RegisterProvider(id string) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[id] = true
+}
+
+func knownProvider(id string) (ok bool, known []string) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if len(providers) == 0 || providers[id] {
+		return true, nil
+	}
+	for p := range providers {
+		known = append(known, p)
+	}
+	sort.Strings(known)
+	return false, known
+}
+
+// Validate checks c and reports all problems at once as a *ValidationError:
+// unknown settings and providers, out of range values and missing files.
+func (c Config) Validate() error {
+	v := validator{src: c.source}
+	if v.src == nil {
+		v.src = &source{}
+	}
+
+	for _, k := range v.src.undecoded {
+		// Report an unknown table once, not each key in it.
+		if len(k) > 1 && v.isUndecoded(k[:len(k)-1]) {
+			continue
+		}
+		v.add(k.String(), "unknown setting")
+	}
+
+	if ok, known := knownProvider(c.LLM.Provider); !ok {
+		v.add("llm.provider", "unknown provider %q (available: %s)", c.LLM.Provider, strings.Join(known, ", "))
+	}
+	if c.LLM.Model == "" {
+		v.add("llm.model", "no model configured")
+	}
+	if c.LLM.MaxTokens < 0 {
+		v.add("llm.max_tokens", "must not be negative")
+	}
+	for name, a := range c.LLM.Auth {
+		if a == nil {
+			continue
+		}
+		if ok, _ := knownProvider(name); !ok {
+			v.add("llm.auth."+name, "unknown provider %q", name)
+		}
+		switch a.Strategy {
+		case "", "apikey", "oauth", "token", "none":
+		default:
+			v.add("llm.auth."+name+".strategy", "unknown strategy %q (want apikey, oauth or none)", a.Strategy)
+		}
+	}
+
+	if p := c.Gateway.Port; p < 1 || p > 65535 {
+		v.add("gateway.port", "%d is out of range 1-65535", p)
+	}
+	if c.Gateway.Host == "" {
+		v.add("gateway.host", "no host configured")
+	}
+	if l := c.Gateway.Listen; l != "localhost" && net.ParseIP(l) == nil {
+		v.add("gateway.listen", "%q is not an IP address, like 127.0.0.1 or 0.0.0.0 for all interfaces", l)
+	} else if !IsLoopback(l) && c.Gateway.Token == "" {
+		v.add("gateway.token", "required when gateway.listen is not a loopback address")
+	}
+	if _, port, err := net.SplitHostPort(c.Node.Listen); err != nil {
+		v.add("node.listen", "%q is not a listen address like \":7332\"", c.Node.Listen)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		v.add("node.listen", "port %q is out of range 1-65535", port)
+	}
+
+	if spec := c.Memory.EmbeddingModel; spec != "" {
+		provider, model, ok := strings.Cut(spec, "/")
+		if !ok || provider == "" || model == "" {
+			v.add("memory.embedding_model", "%q is not of the form provider/model", spec)
+		} else if ok, known := knownProvider(provider); !ok {
+			v.add("memory.embedding_model", "unknown provider %q (available: %s)", provider, strings.Join(known, ", "))
+		}
+	}
+	if c.Memory.VecExtension != "" {
+		v.checkFile("memory.vec_extension", c.Memory.VecExtension)
+	}
+	if c.Memory.WorkingMemory < 0 {
+		v.add("memory.working_memory", "must not be negative")
+	}
+	if at := c.Memory.ConsolidateAt; at != "" {
+		if _, err := time.Parse("15:04", at); err != nil {
+			v.add("memory.consolidate_at", "%q is not a time of day like \"03:00\"", at)
+		}
+	}
+	for typ, days := range c.Memory.HalfLifeDays {
+		if _, ok := defaults().Memory.HalfLifeDays[typ]; !ok {
+			v.add("memory.half_life_days."+typ, "unknown memory type")
+		} else if days < 0 {
+			v.add("memory.half_life_days."+typ, "must not be negative")
+		}
+	}
+	if m := c.Memory.MinSimilarity; m < 0 || m > 1 {
+		v.add("memory.min_similarity", "%v is outside 0..1", m)
+	}
+	if b := c.Memory.ArchiveBelow; b < 0 || b > 1 {
+		v.add("memory.archive_below", "%v is outside 0..1", b)
+	}
+	if c.Memory.ArchiveDays < 0 {
+		v.add("memory.archive_days", "must not be negative")
+	}
+
+	for name, n := range c.Nodes {
+		key := "nodes." + name
+		if n.Host == "" {
+			v.add(key+".host", "no host configured")
+		} else if _, port, err := net.SplitHostPort(n.Host); err == nil {
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				v.add(key+".host", "port %q is out of range 1-65535", port)
+			}
+		}
+		if n.Key != "" {
+			v.checkFile(key+".key", n.Key)
+		}
+		if n.Certificate != "" {
+			v.checkFile(key+".certificate", n.Certificate)
+		}
+		for _, list := range []struct {
+			name     string
+			patterns []string
+		}{{"allow", n.Allow}, {"deny", n.Deny}} {
+			for _, p := range list.patterns {
+				if strings.TrimSpace(p) == "" {
+					v.add(key+"."+list.name, "empty pattern")
+				}
+			}
+		}
+	}
+
+	if c.Encryption.Enabled() && c.Encryption.KeyFile != "" {
+		v.checkFile("encryption.key_file", c.Encryption.KeyFile)
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Key < b.Key
+	})
+	return &ValidationError{Path: v.src.path, Problems: v.problems}
+}
+
+type validator struct {
+	src      *source
+	problems []Problem
+}
+
+func (v *validator) add(key, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Key: key, Line: v.line(key), Msg: fmt.Sprintf(format, args...)})
+}
+
+// line returns the line of key, or of the closest enclosing table, so that
+// defaults-filled settings of a table point at its header.
+func (v *validator) line(key string) int {
+	for {
+		if l, ok := v.src.lines[key]; ok {
+			return l
+		}
+		i := strings.LastIndexByte(key, '.')
+		if i < 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+}
+
+func (v *validator) isUndecoded(k toml.Key) bool {
+	for _, u := range v.src.undecoded {
+		if u.String() == k.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) checkFile(key, path string) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			v.add(key, "%s does not exist", path)
+		} else {
+			v.add(key, "%v", err)
+		}
+	}
+}
+
+// keyLines maps the dotted keys and table headers in a TOML document to
+// the lines defining them. It is a line-based scan for error messages only;
+// keys inside multi-line values may be missed.
+func keyLines(data string) map[string]int {
+	lines := make(map[string]int)
+	var table []string
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "[["):
+			table = splitKey(strings.TrimSuffix(strings.TrimPrefix(line, "[["), "]]"))
+			setLine(lines, table, i+1)
+		case strings.HasPrefix(line, "["):
+			table = splitKey(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			setLine(lines, table, i+1)
+		default:
+			k, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			full := append(append([]string(nil), table...), splitKey(k)...)
+			setLine(lines, full, i+1)
+		}
+	}
+	return lines
+}
+
+func setLine(lines map[string]int, key []string, line int) {
+	k := toml.Key(key).String()
+	if _, ok := lines[k]; !ok {
+		lines[k] = line
+	}
+}
+
+// splitKey splits a dotted TOML key, unquoting its parts.
+func splitKey(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			parts = append(parts, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(parts, strings.TrimSpace(cur.String()))
+}
+
+// stripComment removes a # comment outside of strings.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
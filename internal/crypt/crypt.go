@@ -0,0 +1,170 @@
+// Package crypt encrypts Poe's data at rest: memory contents in the database
+// and the credentials in config.toml. A 256-bit key is derived from a
+// passphrase or the contents of a key file with Argon2id, and values are
+// sealed with AES-256-GCM into printable strings that can sit in a TEXT
+// column or a TOML string.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// prefix marks sealed values. Values without it are plaintext, which lets
+// a store hold both while encryption is being switched on.
+const prefix = "enc:v1:"
+
+// Argon2id parameters, the second recommended option of RFC 9106.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	keyLen       = 32
+	saltLen      = 16
+)
+
+// checkValue is sealed into Params.Check to recognize the right key.
+const checkValue = "poe"
+
+var (
+	// ErrWrongKey is returned when a passphrase or key file does not match
+	// the key the data was encrypted with.
+	ErrWrongKey = errors.New("wrong passphrase or key file")
+	// ErrCorrupt is returned for sealed values that cannot be decoded.
+	ErrCorrupt = errors.New("corrupt encrypted value")
+)
+
+// Params are what, besides the secret, is needed to derive a key and verify
+// it. They are not secret and are stored next to the encrypted data.
+type Params struct {
+	Salt  string `toml:"salt,omitempty"`  // base64
+	Check string `toml:"check,omitempty"` // a known value sealed with the key
+}
+
+// Cipher seals and opens values with one key. It is safe for concurrent use.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewKey derives a key from secret with a fresh salt and returns it together
+// with the Params to store for Unlock.
+func NewKey(secret []byte) (*Cipher, Params, error) {
+	if len(secret) == 0 {
+		return nil, Params{}, errors.New("empty passphrase")
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, Params{}, err
+	}
+	c, err := derive(secret, salt)
+	if err != nil {
+		return nil, Params{}, err
+	}
+	return c, Params{Salt: base64.StdEncoding.EncodeToString(salt), Check: c.Seal(checkValue)}, nil
+}
+
+// Unlock derives the key for p from secret. It returns ErrWrongKey if secret
+// is not the one p was created with.
+func Unlock(secret []byte, p Params) (*Cipher, error) {
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("encryption salt: %w", ErrCorrupt)
+	}
+	c, err := derive(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Verify(p.Check); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func derive(secret, salt []byte) (*Cipher, error) {
+	key := argon2.IDKey(secret, salt, argonTime, argonMemory, argonThreads, keyLen)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// CheckValue returns a fresh check for c, for stores that verify the key
+// independently of the Params it was derived with.
+func (c *Cipher) CheckValue() string {
+	return c.Seal(checkValue)
+}
+
+// Verify reports whether check was produced by CheckValue with c's key.
+func (c *Cipher) Verify(check string) error {
+	if !IsSealed(check) {
+		return ErrWrongKey
+	}
+	if v, err := c.Open(check); err != nil || v != checkValue {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// Seal encrypts plaintext under a random nonce.
+func (c *Cipher) Seal(plaintext string) string {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Open decrypts a value made by Seal. Values that are not sealed are
+// returned unchanged.
+func (c *Cipher) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(value[len(prefix):])
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
+
+// IsSealed reports whether value was produced by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// NewKeyFile writes a random secret to a new file at path, readable by the
+// owner only.
+func NewKeyFile(path string) error {
+	secret := make([]byte, keyLen)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(secret) + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package crypt_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/crypt"
+)
+
+func TestSealOpen(t *testing.T) {
+	c, params, err := crypt.NewKey([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	sealed := c.Seal("ha-server root password is hunter2")
+	if !crypt.IsSealed(sealed) || strings.Contains(sealed, "hunter2") {
+		t.Fatalf("Seal() = %q", sealed)
+	}
+	if sealed == c.Seal("ha-server root password is hunter2") {
+		t.Error("Seal() is deterministic, want a fresh nonce per value")
+	}
+
+	unlocked, err := crypt.Unlock([]byte("correct horse"), params)
+	if err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if got, err := unlocked.Open(sealed); err != nil || got != "ha-server root password is hunter2" {
+		t.Errorf("Open() = %q, %v", got, err)
+	}
+	if got, err := unlocked.Open("plain"); err != nil || got != "plain" {
+		t.Errorf("Open(plaintext) = %q, %v; want it unchanged", got, err)
+	}
+
+	if _, err := crypt.Unlock([]byte("battery staple"), params); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("Unlock(wrong passphrase) error = %v, want ErrWrongKey", err)
+	}
+	other, _, _ := crypt.NewKey([]byte("battery staple"))
+	if _, err := other.Open(sealed); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("Open() with another key error = %v, want ErrWrongKey", err)
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// sameOrigin reports whether r was not sent by a web page of another origin.
+// Clients other than browsers send no Origin header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// local reports whether r comes from this machine: over the Unix socket, or
+// from a loopback address to a loopback host name. The Host check stops web
+// pages that point their own domain at 127.0.0.1.
+func local(r *http.Request) bool {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return true // the Unix socket has no remote address
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return config.IsLoopback(ip) && config.IsLoopback(host)
+}
+
+// validToken reports whether token is the configured gateway token. No token
+// is valid when none is configured.
+func (g *Gateway) validToken(token string) bool {
+	want := g.Config().Gateway.Token
+	if want == "" || token == "" {
+		return false
+	}
+	want, err := config.ResolveSecret(want)
+	if err != nil {
+		log.Printf("Gateway token: %v", err)
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// guard refuses REST requests from web pages of other origins, and requests
+// from other machines that do not carry the gateway token as a bearer token.
+func (g *Gateway) guard(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		if !local(r) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !g.validToken(token) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing or wrong gateway token", http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
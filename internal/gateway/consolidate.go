@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/google/uuid"
+)
+
+// consolidationBatch is how many episodic memories one consolidation run
+// reads; related memories and facts are shown to the model alongside them.
+const (
+	consolidationBatch   = 50
+	consolidationRelated = 3 // per episode
+	consolidationFacts   = 200
+)
+
+const consolidationPrompt = `You maintain Poe's long-term memory. You are given new episodic memories
+(things that happened recently), existing long-term memories that may be
+related, and the known facts about the owner and the homelab.
+
+Decide how long-term memory should change. For every piece of durable
+knowledge in the new episodes, choose one operation:
+- "add": it is new. Add a memory ("target": "memory", with "content" and
+  "type" "semantic" or "procedural") or a fact ("target": "fact", with a
+  dotted "key" such as "homelab.ha-server.os" and the value in "content").
+- "update": it refines or corrects an existing memory (by "id") or fact (by
+  "key"); give the full new "content".
+- "delete": it shows an existing memory or fact is wrong or obsolete.
+- "noop": it is already known or not worth remembering.
+Only use ids and keys that appear in the input. Give every operation a short
+"reason". Memories may carry an "importance" and facts a "confidence", both
+between 0 and 1.
+
+Respond with a single JSON object and nothing else:
+{"operations": [{"op": "...", "target": "...", "id": "...", "key": "...", "content": "...", "type": "...", "importance": 0.5, "confidence": 0.9, "reason": "..."}]}`
+
+// Consolidate folds the oldest batch of unconsolidated episodic memories into
+// long-term memory: the model proposes operations on memories and facts,
+// which are applied in one transaction and recorded in the audit log. It
+// returns the number of episodes processed, which is zero once none are left.
+func (g *Gateway) Consolidate(ctx context.Context) (int, error) {
+	episodes, err := g.memory.UnconsolidatedEpisodes(ctx, consolidationBatch)
+	if err != nil || len(episodes) == 0 {
+		return 0, err
+	}
+
+	known := make(map[string]bool)
+	var sources []string
+	var sb strings.Builder
+	sb.WriteString("## New episodes\n")
+	for _, ep := range episodes {
+		known[ep.ID] = true
+		sources = append(sources, ep.ID)
+		fmt.Fprintf(&sb, "- [%s] (%s) %s\n", ep.ID, ep.CreatedAt.Format("2006-01-02 15:04"), ep.Content)
+	}
+
+	sb.WriteString("\n## Existing memories\n")
+	for _, ep := range episodes {
+		related, err := g.memory.Related(ctx, ep.Content, consolidationRelated)
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range related {
+			if known[m.ID] {
+				continue
+			}
+			known[m.ID] = true
+			fmt.Fprintf(&sb, "- [%s] (%s, importance %.2f) %s\n", m.ID, m.Type, m.Importance, m.Content)
+		}
+	}
+
+	facts, err := g.memory.ListFacts(ctx, "", memory.Page{Limit: consolidationFacts})
+	if err != nil {
+		return 0, err
+	}
+	sb.WriteString("\n## Known facts\n")
+	for _, f := range facts {
+		fmt.Fprintf(&sb, "- %s = %s (confidence %.2f)\n", f.Key, f.Value, f.Confidence)
+	}
+
+	resp, err := g.state.Load().llm.Chat(ctx, ai.Request{Messages: []ai.Message{
+		{Role: ai.RoleSystem, Content: consolidationPrompt},
+		{Role: ai.RoleUser, Content: sb.String()},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("consolidate: %w", err)
+	}
+	ops, err := parseOperations(resp.Message.Content)
+	if err != nil {
+		return 0, fmt.Errorf("consolidate: %w", err)
+	}
+
+	// The model may only touch memories it was shown.
+	valid := ops[:0]
+	for _, op := range ops {
+		if op.Target == memory.TargetMemory && (op.Op == memory.OpUpdate || op.Op == memory.OpDelete) && !known[op.ID] {
+			log.Printf("consolidation: ignoring %s of unknown memory %q", op.Op, op.ID)
+			continue
+		}
+		valid = append(valid, op)
+	}
+
+	runID := uuid.New().String()
+	changes, skipped, err := g.memory.ApplyConsolidation(ctx, runID, sources, valid)
+	if err != nil {
+		return 0, fmt.Errorf("consolidate: %w", err)
+	}
+	for _, sk := range skipped {
+		log.Printf("consolidation %s: ignoring %s of %s: %v", runID, sk.Op.Op, sk.Op.Target, sk.Err)
+	}
+	for _, c := range changes {
+		log.Printf("consolidation %s: %s %s %s: %q → %q (%s)", runID, c.Op, c.Target, c.Ref, c.Before, c.After, c.Reason)
+	}
+	log.Printf("consolidation %s: %d episodes, %d changes", runID, len(episodes), len(changes))
+	return len(episodes), nil
+}
+
+// parseOperations extracts the operations from the model's reply, tolerating
+// prose or a code fence around the JSON object.
+func parseOperations(reply string) ([]memory.Operation, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in model reply")
+	}
+	var out struct {
+		Operations []memory.Operation `json:"operations"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &out); err != nil {
+		return nil, fmt.Errorf("decode operations: %w", err)
+	}
+	return out.Operations, nil
+}
+
+// consolidateNightly runs Consolidate until all episodes are processed, then
+// decays memory importance, every day at the local time at ("15:04"), until
+// ctx is done.
+func (g *Gateway) consolidateNightly(ctx context.Context, at string) {
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		log.Printf("consolidation disabled: invalid consolidate_at %q", at)
+		return
+	}
+	for {
+		next := nextDaily(time.Now(), clock.Hour(), clock.Minute())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		for {
+			n, err := g.Consolidate(ctx)
+			if err != nil {
+				log.Printf("consolidation: %v", err)
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+		g.decay(ctx)
+	}
+}
+
+// decay applies the configured decay policy and archives faded memories.
+func (g *Gateway) decay(ctx context.Context) {
+	res, err := g.memory.Decay(ctx, decayPolicy(g.Config().Memory), time.Now())
+	if err != nil {
+		log.Printf("decay: %v", err)
+		return
+	}
+	log.Printf("decay: %d memories faded, %d archived, %d purged from the archive", res.Decayed, res.Archived, res.Purged)
+}
+
+func decayPolicy(cfg config.MemoryConfig) memory.DecayPolicy {
+	const day = 24 * time.Hour
+	p := memory.DecayPolicy{
+		HalfLife:         make(map[memory.MemoryType]time.Duration),
+		ArchiveBelow:     cfg.ArchiveBelow,
+		ArchiveRetention: time.Duration(cfg.ArchiveDays) * day,
+	}
+	for typ, days := range cfg.HalfLifeDays {
+		p.HalfLife[memory.MemoryType(typ)] = time.Duration(days * float64(day))
+	}
+	return p
+}
+
+// nextDaily returns the first time after now at hour:min local time.
+func nextDaily(now time.Time, hour, min int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, min, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
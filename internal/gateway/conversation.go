@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"log"
+
+	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/protocol"
+)
+
+// workingMemory trims a window of recent messages so that it starts at a user
+// message; tool results are never separated from the assistant message that
+// requested them.
+func workingMemory(msgs []ai.Message) []ai.Message {
+	for len(msgs) > 0 && msgs[0].Role != ai.RoleUser {
+		msgs = msgs[1:]
+	}
+	return msgs
+}
+
+// sendHistory replays the visible part of a resumed session's working memory
+// to the client.
+func (g *Gateway) sendHistory(ctx context.Context, conn *protocol.Conn, sessionID string) error {
+	history, err := g.memory.RecentMessages(ctx, sessionID, g.Config().Memory.WorkingMemory)
+	if err != nil {
+		return err
+	}
+	var h protocol.History
+	for _, m := range workingMemory(history) {
+		if m.Content == "" || (m.Role != ai.RoleUser && m.Role != ai.RoleAssistant) {
+			continue
+		}
+		h.Messages = append(h.Messages, protocol.HistoryMessage{Role: m.Role, Content: m.Content})
+	}
+	return conn.Send(protocol.TypeHistory, "", h)
+}
+
+// respond runs one turn of a session through the agent and streams the reply
+// to conn, tagging everything it sends with the request's id. The session's
+// working memory is sent along with the new message. The message is added to
+// the transcript before the agent runs, so that it is kept even if no reply
+// comes, and the reply is added once complete. Model and tool errors are
+// reported to the client; only write errors are returned.
+func (g *Gateway) respond(ctx context.Context, conn *protocol.Conn, sessionID, id, text string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the model if the client goes away mid-reply
+
+	var writeErr error
+	send := func(typ protocol.Type, payload interface{}) {
+		if writeErr != nil {
+			return
+		}
+		if writeErr = conn.Send(typ, id, payload); writeErr != nil {
+			cancel()
+		}
+	}
+	fail := func(err error) error {
+		log.Printf("session %s: %v", sessionID, err)
+		send(protocol.TypeError, protocol.Error{Code: protocol.CodeInternal, Message: err.Error()})
+		return writeErr
+	}
+
+	// The whole turn runs with the state at its start, even if Reload
+	// replaces it meanwhile.
+	st := g.state.Load()
+	var messages []ai.Message
+	if st.prompt != "" {
+		messages = append(messages, ai.Message{Role: ai.RoleSystem, Content: st.prompt})
+	}
+	history, err := g.memory.RecentMessages(ctx, sessionID, st.config.Memory.WorkingMemory)
+	if err != nil {
+		return fail(err)
+	}
+	user := ai.Message{Role: ai.RoleUser, Content: text}
+	messages = append(messages, workingMemory(history)...)
+	messages = append(messages, user)
+	if err := g.memory.AppendMessages(ctx, sessionID, user); err != nil {
+		return fail(err)
+	}
+
+	added, err := g.agent.Run(ctx, st.llm, messages, func(ev agent.Event) {
+		switch ev.Type {
+		case agent.EventDelta:
+			send(protocol.TypeDelta, protocol.Delta{Content: ev.Delta})
+		case agent.EventToolCall:
+			send(protocol.TypeToolCall, protocol.ToolCall{Name: ev.ToolCall.Name, Arguments: ev.ToolCall.Arguments})
+		case agent.EventToolResult:
+			res := protocol.ToolResult{Name: ev.ToolCall.Name, Result: ev.Result}
+			if ev.Err != nil {
+				res.Error = ev.Err.Error()
+			}
+			send(protocol.TypeToolResult, res)
+		}
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := g.memory.AppendMessages(ctx, sessionID, added...); err != nil {
+		return fail(err)
+	}
+	var reply string
+	if n := len(added); n > 0 && added[n-1].Role == ai.RoleAssistant {
+		reply = added[n-1].Content
+	}
+	send(protocol.TypeDone, protocol.Done{Content: reply})
+	return writeErr
+}
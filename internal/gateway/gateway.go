@@ -2,45 +2,62 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/ai"
 	"github.com/fjrt/poeai/internal/config"
 	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/protocol"
+	"github.com/fjrt/poeai/internal/soul"
+	"github.com/fjrt/poeai/internal/ssh"
 	"github.com/gorilla/websocket"
 )
 
+// upgrader refuses WebSocket requests from web pages of other origins.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true }, // Local loopback only in dev
+	CheckOrigin:     sameOrigin,
 }
 
 type Gateway struct {
-	config  config.Config
+	state   atomic.Pointer[state] // replaced by Reload
 	memory  *memory.Store
 	agent   *agent.Agent
-	clients map[*websocket.Conn]bool
+	soul    *soul.Manager
+	nodes   *ssh.Pool
+	clients map[*protocol.Conn]bool
 	mu      sync.Mutex
 }
 
-func New(cfg config.Config, m *memory.Store, a *agent.Agent) *Gateway {
-	return &Gateway{
-		config:  cfg,
+func New(cfg config.Config, m *memory.Store, a *agent.Agent, llm ai.Client, s *soul.Manager) *Gateway {
+	g := &Gateway{
 		memory:  m,
 		agent:   a,
-		clients: make(map[*websocket.Conn]bool),
+		soul:    s,
+		nodes:   ssh.NewPool(ssh.Options{}),
+		clients: make(map[*protocol.Conn]bool),
 	}
+	g.state.Store(newState(cfg, llm, s))
+	a.RegisterNodeTools(g)
+	return g
 }
 
 func (g *Gateway) Run(ctx context.Context) error {
+	cfg := g.Config()
+	defer g.nodes.Close()
+
 	// 1. Listen on TCP (remote/local)
-	addr := fmt.Sprintf(":%d", g.config.Gateway.Port)
+	addr := net.JoinHostPort(cfg.Gateway.Listen, strconv.Itoa(cfg.Gateway.Port))
 	server := &http.Server{
 		Addr:    addr,
 		Handler: g.mux(),
@@ -56,15 +73,15 @@ func (g *Gateway) Run(ctx context.Context) error {
 	}()
 
 	// 2. Listen on Unix Socket (local fast)
-	if g.config.Gateway.Socket != "" {
-		if err := os.RemoveAll(g.config.Gateway.Socket); err != nil {
+	if cfg.Gateway.Socket != "" {
+		if err := os.RemoveAll(cfg.Gateway.Socket); err != nil {
 			return err
 		}
-		unixListener, err := net.Listen("unix", g.config.Gateway.Socket)
+		unixListener, err := net.Listen("unix", cfg.Gateway.Socket)
 		if err != nil {
 			return err
 		}
-		log.Printf("Gateway Unix socket listening on %s", g.config.Gateway.Socket)
+		log.Printf("Gateway Unix socket listening on %s", cfg.Gateway.Socket)
 		go func() {
 			if err := http.Serve(unixListener, g.mux()); err != nil && err != http.ErrServerClosed {
 				errChan <- err
@@ -72,6 +89,10 @@ func (g *Gateway) Run(ctx context.Context) error {
 		}()
 	}
 
+	if at := cfg.Memory.ConsolidateAt; at != "" {
+		go g.consolidateNightly(ctx, at)
+	}
+
 	select {
 	case <-ctx.Done():
 		return server.Shutdown(context.Background())
@@ -80,9 +101,16 @@ func (g *Gateway) Run(ctx context.Context) error {
 	}
 }
 
+// Handler returns the HTTP handler that Run serves on TCP and the Unix socket.
+func (g *Gateway) Handler() http.Handler {
+	return g.mux()
+}
+
 func (g *Gateway) mux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", g.handleWS)
+	mux.HandleFunc("GET /sessions", g.guard(g.handleSessions))
+	g.routeMemory(mux)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "OK")
@@ -90,17 +118,17 @@ func (g *Gateway) mux() *http.ServeMux {
 	return mux
 }
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
+// handleWS serves one client connection using the protocol package. After
+// the handshake, chat requests are answered one at a time in the background
+// so that pings are still answered while Poe is replying; a chat sent while a
+// reply is in progress is refused as busy.
 func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WS upgrade error: %v", err)
 		return
 	}
+	conn := protocol.NewConn(ws)
 	defer conn.Close()
 
 	g.mu.Lock()
@@ -113,24 +141,126 @@ func (g *Gateway) handleWS(w http.ResponseWriter, r *http.Request) {
 		g.mu.Unlock()
 	}()
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel() // stops a reply in progress when the client goes away
+
+	sess, err := g.handshake(ctx, conn, local(r))
+	if err != nil {
+		log.Printf("WS handshake: %v", err)
+		return
+	}
+
+	busy := make(chan struct{}, 1)
 	for {
-		var msg Message
-		err := conn.ReadJSON(&msg)
+		env, err := conn.Receive()
 		if err != nil {
 			log.Printf("WS read error: %v", err)
-			break
+			return
 		}
 
-		log.Printf("Received: %s", msg.Content)
-
-		// For now: echo back as Poe
-		resp := Message{
-			Role:    "poe",
-			Content: fmt.Sprintf("I received your message: %s. I am initializing my consciousness.", msg.Content),
+		switch env.Type {
+		case protocol.TypePing:
+			err = conn.Send(protocol.TypePong, env.ID, nil)
+		case protocol.TypeChat:
+			var chat protocol.Chat
+			if err = env.Decode(&chat); err != nil {
+				err = conn.SendError(env.ID, protocol.CodeBadRequest, err.Error())
+				break
+			}
+			select {
+			case busy <- struct{}{}:
+				log.Printf("Received: %s", chat.Content)
+				wg.Add(1)
+				go func(id, text string) {
+					defer wg.Done()
+					defer func() { <-busy }()
+					if err := g.respond(ctx, conn, sess.ID, id, text); err != nil {
+						log.Printf("WS write error: %v", err)
+						conn.Close()
+					}
+				}(env.ID, chat.Content)
+			default:
+				err = conn.SendError(env.ID, protocol.CodeBusy, "a reply is already in progress")
+			}
+		default:
+			err = conn.SendError(env.ID, protocol.CodeBadRequest, fmt.Sprintf("unexpected %s message", env.Type))
 		}
-		if err := conn.WriteJSON(resp); err != nil {
+		if err != nil {
 			log.Printf("WS write error: %v", err)
-			break
+			return
 		}
 	}
 }
+
+// handshake waits for the client's hello, then starts or resumes its session
+// and answers with welcome. Clients that cannot be served are sent an error,
+// as are clients not on this machine that do not send the gateway token.
+func (g *Gateway) handshake(ctx context.Context, conn *protocol.Conn, local bool) (memory.Session, error) {
+	env, err := conn.Receive()
+	if err != nil {
+		return memory.Session{}, err
+	}
+	refuse := func(code, msg string) (memory.Session, error) {
+		conn.SendError(env.ID, code, msg)
+		return memory.Session{}, &protocol.Error{Code: code, Message: msg}
+	}
+
+	var hello protocol.Hello
+	if env.Type != protocol.TypeHello {
+		return refuse(protocol.CodeBadRequest, fmt.Sprintf("expected hello, got %s", env.Type))
+	}
+	if err := env.Decode(&hello); err != nil {
+		return refuse(protocol.CodeBadRequest, err.Error())
+	}
+	if !local && !g.validToken(hello.Token) {
+		return refuse(protocol.CodeUnauthorized, "missing or wrong gateway token")
+	}
+	version, err := protocol.Negotiate(hello.Version)
+	if err != nil {
+		return refuse(protocol.CodeUnsupportedVersion, err.(*protocol.Error).Message)
+	}
+
+	var sess memory.Session
+	resumed := hello.Session != ""
+	if resumed {
+		s, ok, err := g.memory.GetSession(ctx, hello.Session)
+		if err != nil {
+			return refuse(protocol.CodeInternal, err.Error())
+		}
+		if !ok {
+			return refuse(protocol.CodeSessionNotFound, fmt.Sprintf("session %s not found", hello.Session))
+		}
+		sess = s
+	} else {
+		s, err := g.memory.CreateSession(ctx)
+		if err != nil {
+			return refuse(protocol.CodeInternal, err.Error())
+		}
+		sess = s
+	}
+	log.Printf("Client %q connected (protocol v%d, session %s)", hello.Client, version, sess.ID)
+
+	welcome := protocol.Welcome{Version: version, Session: sess.ID, Resumed: resumed}
+	if err := conn.Send(protocol.TypeWelcome, env.ID, welcome); err != nil {
+		return memory.Session{}, err
+	}
+	if resumed {
+		if err := g.sendHistory(ctx, conn, sess.ID); err != nil {
+			return memory.Session{}, fmt.Errorf("session %s: history: %w", sess.ID, err)
+		}
+	}
+	return sess, nil
+}
+
+// handleSessions lists recent sessions for clients offering to resume one.
+func (g *Gateway) handleSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := g.memory.ListSessions(r.Context(), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
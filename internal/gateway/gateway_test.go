@@ -0,0 +1,487 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fjrt/poeai/internal/agent"
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/gateway"
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/protocol"
+	"github.com/fjrt/poeai/internal/soul"
+	"github.com/gorilla/websocket"
+)
+
+// echoLLM answers every request with the last user message, and records the
+// system prompt it was given.
+type echoLLM struct {
+	system   string
+	messages int
+}
+
+func (e *echoLLM) Name() string { return "echo" }
+
+func (e *echoLLM) Completion(ctx context.Context, messages []ai.Message) (string, error) {
+	resp, err := e.Chat(ctx, ai.Request{Messages: messages})
+	return resp.Message.Content, err
+}
+
+func (e *echoLLM) Chat(ctx context.Context, req ai.Request) (ai.Response, error) {
+	events, _ := e.Stream(ctx, req)
+	return ai.CollectResponse(events)
+}
+
+func (e *echoLLM) Stream(ctx context.Context, req ai.Request) (<-chan ai.StreamEvent, error) {
+	e.messages = len(req.Messages)
+	last := req.Messages[len(req.Messages)-1]
+	if req.Messages[0].Role == ai.RoleSystem {
+		e.system = req.Messages[0].Content
+	}
+	ch := make(chan ai.StreamEvent, 3)
+	ch <- ai.StreamEvent{Delta: "You said: "}
+	ch <- ai.StreamEvent{Delta: last.Content}
+	ch <- ai.StreamEvent{Done: true}
+	close(ch)
+	return ch, nil
+}
+
+// newTestGateway serves a gateway on the default configuration, changed by
+// opts.
+func newTestGateway(t *testing.T, llm ai.Client, opts ...func(*config.Config)) *httptest.Server {
+	t.Helper()
+	mem, err := memory.Open(":memory:")
+	if err != nil {
+		t.Fatalf("memory.Open() error = %v", err)
+	}
+	t.Cleanup(func() { mem.Close() })
+	sm := soul.New(t.TempDir())
+	if err := sm.Init(); err != nil {
+		t.Fatalf("soul.Init() error = %v", err)
+	}
+	cfg, _ := config.Load("")
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	g := gateway.New(cfg, mem, agent.New(mem), llm, sm)
+	ts := httptest.NewServer(g.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// dial connects to ts and completes the handshake with hello.
+func dial(t *testing.T, ts *httptest.Server, hello protocol.Hello) (*protocol.Conn, protocol.Welcome, error) {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn := protocol.NewConn(ws)
+	t.Cleanup(func() { conn.Close() })
+	welcome, err := protocol.Handshake(conn, hello)
+	return conn, welcome, err
+}
+
+// chat sends a chat request and reads until its "done", returning the
+// concatenated deltas.
+func chat(t *testing.T, conn *protocol.Conn, id, text string) string {
+	t.Helper()
+	if err := conn.Send(protocol.TypeChat, id, protocol.Chat{Content: text}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	var sb strings.Builder
+	for {
+		env, err := conn.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		if env.ID != id {
+			t.Fatalf("%s message has id %q, want %q", env.Type, env.ID, id)
+		}
+		switch env.Type {
+		case protocol.TypeDelta:
+			var d protocol.Delta
+			env.Decode(&d)
+			sb.WriteString(d.Content)
+		case protocol.TypeError:
+			t.Fatalf("gateway error: %s", env.Payload)
+		case protocol.TypeDone:
+			var d protocol.Done
+			env.Decode(&d)
+			if d.Content != sb.String() {
+				t.Errorf("done content = %q, want %q", d.Content, sb.String())
+			}
+			return sb.String()
+		}
+	}
+}
+
+func TestGateway_Conversation(t *testing.T) {
+	llm := &echoLLM{}
+	conn, welcome, err := dial(t, newTestGateway(t, llm), protocol.Hello{Client: "test"})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if welcome.Version != protocol.Version || welcome.Session == "" || welcome.Resumed {
+		t.Errorf("welcome = %+v", welcome)
+	}
+
+	if got := chat(t, conn, "1", "hello"); got != "You said: hello" {
+		t.Errorf("reply = %q", got)
+	}
+	if !strings.Contains(llm.system, "You are Poe") {
+		t.Errorf("system prompt = %q, want AGENTS.md persona", llm.system)
+	}
+
+	chat(t, conn, "2", "again")
+	// system + first user + first reply + new user
+	if llm.messages != 4 {
+		t.Errorf("second turn sent %d messages, want 4", llm.messages)
+	}
+
+	conn.Send(protocol.TypePing, "p", nil)
+	if env, err := conn.Receive(); err != nil || env.Type != protocol.TypePong || env.ID != "p" {
+		t.Errorf("ping answered with %+v, %v; want pong", env, err)
+	}
+}
+
+func TestGateway_Auth(t *testing.T) {
+	ts := newTestGateway(t, &echoLLM{}, func(c *config.Config) { c.Gateway.Token = "s3cret" })
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	// A web page of another origin cannot open the WebSocket.
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin Dial() = %v, %v; want 403", resp, err)
+	}
+
+	// A client addressing the gateway by another name is not local and
+	// needs the token.
+	for _, tc := range []struct {
+		token string
+		ok    bool
+	}{{"", false}, {"wrong", false}, {"s3cret", true}} {
+		ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Host": {"poe.lan"}})
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		conn := protocol.NewConn(ws)
+		_, err = protocol.Handshake(conn, protocol.Hello{Client: "test", Token: tc.token})
+		conn.Close()
+		var perr *protocol.Error
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("Handshake() with token %q error = %v", tc.token, err)
+		case !tc.ok && !(errors.As(err, &perr) && perr.Code == protocol.CodeUnauthorized):
+			t.Errorf("Handshake() with token %q error = %v, want %s", tc.token, err, protocol.CodeUnauthorized)
+		}
+	}
+
+	// A local client needs none.
+	if _, _, err := dial(t, ts, protocol.Hello{Client: "test"}); err != nil {
+		t.Errorf("local Handshake() error = %v", err)
+	}
+}
+
+func TestGateway_ResumeSession(t *testing.T) {
+	ts := newTestGateway(t, &echoLLM{})
+	conn, first, err := dial(t, ts, protocol.Hello{Client: "test"})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	chat(t, conn, "1", "remember me")
+	conn.Close()
+
+	conn, welcome, err := dial(t, ts, protocol.Hello{Client: "test", Session: first.Session})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if welcome.Session != first.Session || !welcome.Resumed {
+		t.Errorf("welcome = %+v, want resumed %s", welcome, first.Session)
+	}
+	env, err := conn.Receive()
+	var h protocol.History
+	if err != nil || env.Type != protocol.TypeHistory || env.Decode(&h) != nil {
+		t.Fatalf("after welcome got %+v, %v; want history", env, err)
+	}
+	if len(h.Messages) != 2 || h.Messages[0].Content != "remember me" || h.Messages[1].Content != "You said: remember me" {
+		t.Errorf("history = %+v", h.Messages)
+	}
+
+	_, _, err = dial(t, ts, protocol.Hello{Client: "test", Session: "nope"})
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeSessionNotFound {
+		t.Errorf("unknown session: err = %v, want %s", err, protocol.CodeSessionNotFound)
+	}
+}
+
+// downLLM fails every request, like an unreachable provider.
+type downLLM struct{}
+
+func (downLLM) Name() string { return "down" }
+
+func (downLLM) Completion(ctx context.Context, messages []ai.Message) (string, error) {
+	return "", errors.New("provider unreachable")
+}
+
+func (downLLM) Chat(ctx context.Context, req ai.Request) (ai.Response, error) {
+	return ai.Response{}, errors.New("provider unreachable")
+}
+
+func (downLLM) Stream(ctx context.Context, req ai.Request) (<-chan ai.StreamEvent, error) {
+	return nil, errors.New("provider unreachable")
+}
+
+func TestGateway_KeepsUnansweredTurn(t *testing.T) {
+	ts := newTestGateway(t, downLLM{})
+	conn, first, err := dial(t, ts, protocol.Hello{Client: "test"})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	conn.Send(protocol.TypeChat, "1", protocol.Chat{Content: "is the NAS up?"})
+	if env, err := conn.Receive(); err != nil || env.Type != protocol.TypeError {
+		t.Fatalf("reply = %+v, %v; want error", env, err)
+	}
+	conn.Close()
+
+	conn, _, err = dial(t, ts, protocol.Hello{Client: "test", Session: first.Session})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	env, err := conn.Receive()
+	var h protocol.History
+	if err != nil || env.Type != protocol.TypeHistory || env.Decode(&h) != nil {
+		t.Fatalf("after welcome got %+v, %v; want history", env, err)
+	}
+	if len(h.Messages) != 1 || h.Messages[0].Role != ai.RoleUser || h.Messages[0].Content != "is the NAS up?" {
+		t.Errorf("history = %+v, want the unanswered question", h.Messages)
+	}
+}
+
+// fixedLLM answers every request with the same reply.
+type fixedLLM struct{ reply string }
+
+func (f fixedLLM) Name() string { return "fixed" }
+
+func (f fixedLLM) Completion(ctx context.Context, messages []ai.Message) (string, error) {
+	return f.reply, nil
+}
+
+func (f fixedLLM) Chat(ctx context.Context, req ai.Request) (ai.Response, error) {
+	return ai.Response{Message: ai.Message{Role: ai.RoleAssistant, Content: f.reply}}, nil
+}
+
+func (f fixedLLM) Stream(ctx context.Context, req ai.Request) (<-chan ai.StreamEvent, error) {
+	ch := make(chan ai.StreamEvent, 2)
+	ch <- ai.StreamEvent{Delta: f.reply}
+	ch <- ai.StreamEvent{Done: true}
+	close(ch)
+	return ch, nil
+}
+
+func TestGateway_Consolidate(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+	ctx := context.Background()
+
+	old, _ := mem.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "ha-server runs Debian 11"})
+	mem.Write(ctx, memory.Memory{Type: memory.TypeEpisodic, Source: "test", Content: "fjrt upgraded ha-server to Debian 12"})
+	mem.Write(ctx, memory.Memory{Type: memory.TypeEpisodic, Source: "test", Content: "fjrt said hi"})
+
+	reply := "```json\n" + `{"operations": [
+		{"op": "update", "target": "memory", "id": "` + old + `", "content": "ha-server runs Debian 12", "reason": "upgraded"},
+		{"op": "add", "target": "fact", "key": "homelab.ha-server.os", "content": "Debian 12", "confidence": 0.9, "reason": "upgraded"},
+		{"op": "delete", "target": "memory", "id": "made-up", "reason": "hallucinated"},
+		{"op": "noop", "target": "memory", "reason": "small talk"}
+	]}` + "\n```"
+	cfg, _ := config.Load("")
+	g := gateway.New(cfg, mem, agent.New(mem), fixedLLM{reply}, soul.New(t.TempDir()))
+
+	n, err := g.Consolidate(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("Consolidate() = %d, %v; want 2 episodes", n, err)
+	}
+	if v, ok, _ := mem.GetFact(ctx, "homelab.ha-server.os"); !ok || v != "Debian 12" {
+		t.Errorf("fact = %q, %v; want Debian 12", v, ok)
+	}
+	results, _ := mem.Search(ctx, "Debian", 5)
+	for _, r := range results {
+		if r.ID == old && r.Content != "ha-server runs Debian 12" {
+			t.Errorf("memory not updated: %q", r.Content)
+		}
+	}
+
+	changes, err := mem.Changes(ctx, 10)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Changes() = %+v, %v; want 2", changes, err)
+	}
+	if c := changes[1]; c.Op != memory.OpUpdate || c.Ref != old || c.Before != "ha-server runs Debian 11" {
+		t.Errorf("first change = %+v", c)
+	}
+
+	if n, err := g.Consolidate(ctx); err != nil || n != 0 {
+		t.Errorf("second Consolidate() = %d, %v; want nothing left", n, err)
+	}
+}
+
+func TestGateway_MemoryREST(t *testing.T) {
+	ts := newTestGateway(t, &echoLLM{})
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do("POST", "/memories", `{"content": "the NAS runs TrueNAS", "type": "semantic"}`)
+	var created memory.Memory
+	if json.NewDecoder(resp.Body).Decode(&created); resp.StatusCode != http.StatusCreated || created.ID == "" {
+		t.Fatalf("POST /memories = %s, %+v", resp.Status, created)
+	}
+
+	resp = do("PATCH", "/memories/"+created.ID, `{"importance": 0.9}`)
+	var updated memory.Memory
+	if json.NewDecoder(resp.Body).Decode(&updated); resp.StatusCode != http.StatusOK || updated.Importance != 0.9 {
+		t.Errorf("PATCH = %s, %+v", resp.Status, updated)
+	}
+
+	resp = do("GET", "/memories?type=semantic&limit=10", "")
+	var listed []memory.Memory
+	if json.NewDecoder(resp.Body).Decode(&listed); len(listed) != 1 {
+		t.Errorf("GET /memories = %+v", listed)
+	}
+
+	if resp = do("DELETE", "/memories/"+created.ID, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %s", resp.Status)
+	}
+	if resp = do("GET", "/memories/"+created.ID, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted memory = %s, want 404", resp.Status)
+	}
+
+	resp = do("PUT", "/facts/homelab.nas.os", `{"value": "TrueNAS", "confidence": 0.8}`)
+	var f memory.Fact
+	if json.NewDecoder(resp.Body).Decode(&f); resp.StatusCode != http.StatusOK || f.Value != "TrueNAS" || f.Confidence != 0.8 {
+		t.Errorf("PUT /facts = %s, %+v", resp.Status, f)
+	}
+	if resp = do("DELETE", "/facts/homelab.nas.os", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE /facts = %s", resp.Status)
+	}
+	if resp = do("GET", "/facts/homelab.nas.os", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted fact = %s, want 404", resp.Status)
+	}
+}
+
+func TestGateway_MemoryRESTAuth(t *testing.T) {
+	ts := newTestGateway(t, &echoLLM{}, func(c *config.Config) { c.Gateway.Token = "s3cret" })
+	do := func(method, path string, header http.Header) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Host = header.Get("Host")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"local", http.Header{}, http.StatusOK},
+		{"cross-origin", http.Header{"Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"remote without token", http.Header{"Host": {"poe.lan"}}, http.StatusUnauthorized},
+		{"remote with wrong token", http.Header{"Host": {"poe.lan"}, "Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		{"remote with token", http.Header{"Host": {"poe.lan"}, "Authorization": {"Bearer s3cret"}}, http.StatusOK},
+	} {
+		if got := do("GET", "/memories", tc.header); got != tc.want {
+			t.Errorf("%s: GET /memories = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+	if got := do("DELETE", "/facts/homelab.nas.os", http.Header{"Host": {"poe.lan"}}); got != http.StatusUnauthorized {
+		t.Errorf("remote DELETE /facts without token = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestGateway_Reload(t *testing.T) {
+	mem, _ := memory.Open(":memory:")
+	defer mem.Close()
+	home := t.TempDir()
+	sm := soul.New(home)
+	sm.Init()
+	cfg, _ := config.Load("")
+	llm := &echoLLM{}
+	g := gateway.New(cfg, mem, agent.New(mem), llm, sm)
+	ts := httptest.NewServer(g.Handler())
+	defer ts.Close()
+
+	conn, _, err := dial(t, ts, protocol.Hello{Client: "test"})
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	chat(t, conn, "1", "hello")
+
+	os.WriteFile(sm.Paths()[0], []byte("You are the new Poe."), 0644)
+	next := cfg
+	next.LLM.Model = "claude-opus-4-6"
+	next.Gateway.Port = cfg.Gateway.Port + 1
+	next.Nodes = map[string]config.NodeConfig{"pi": {Host: "pi.lan"}}
+	restart := g.Reload(next, fixedLLM{"reloaded"})
+
+	if want := []string{"gateway.port"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("Reload() = %v, want %v", restart, want)
+	}
+	if got := chat(t, conn, "2", "hello"); got != "reloaded" {
+		t.Errorf("reply after reload = %q, want the new model's", got)
+	}
+	if _, ok := g.Config().Nodes["pi"]; !ok {
+		t.Error("node list not reloaded")
+	}
+
+	echo := &echoLLM{}
+	g.Reload(next, echo)
+	chat(t, conn, "3", "hello")
+	if !strings.HasPrefix(echo.system, "You are the new Poe.") {
+		t.Errorf("system prompt after reload = %q", echo.system)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go gateway.WatchFiles(ctx, 10*time.Millisecond, changed, path)
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("change reported before any write")
+	default:
+	}
+	os.WriteFile(path, []byte("[llm]\n"), 0600)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating the file was not reported")
+	}
+}
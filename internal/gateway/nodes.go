@@ -0,0 +1,25 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/ssh"
+)
+
+// Nodes returns the nodes of the current configuration, for the agent's
+// node tools.
+func (g *Gateway) Nodes() map[string]config.NodeConfig {
+	return g.Config().Nodes
+}
+
+// Exec runs cmd on the named node over its pooled connection. A node whose
+// configuration changed on reload is reconnected.
+func (g *Gateway) Exec(ctx context.Context, node, cmd string) (ssh.Result, error) {
+	n, ok := g.Nodes()[node]
+	if !ok {
+		return ssh.Result{}, fmt.Errorf("no node %q", node)
+	}
+	return g.nodes.Client(node, n).Exec(ctx, cmd)
+}
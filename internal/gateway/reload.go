@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/soul"
+)
+
+// state is what the gateway derives from its configuration. Reload replaces
+// it as a whole, so that a turn sees either the old or the new state, never
+// a mix of both.
+type state struct {
+	config config.Config
+	llm    ai.Client
+	prompt string // soul prompt; empty if it could not be read
+}
+
+func newState(cfg config.Config, llm ai.Client, s *soul.Manager) *state {
+	st := &state{config: cfg, llm: llm}
+	if s != nil {
+		prompt, err := s.GetPrompt()
+		if err != nil {
+			log.Printf("soul: %v", err)
+		}
+		st.prompt = prompt
+	}
+	return st
+}
+
+// restartKeys are the settings read only when the gateway starts: the
+// listeners, the memory store and the consolidation schedule. Keys ending in a
+// dot stand for a whole table.
+var restartKeys = []string{
+	"gateway.port",
+	"gateway.listen",
+	"gateway.socket",
+	"memory.db_path",
+	"memory.embedding_model",
+	"memory.vec_extension",
+	"memory.min_similarity",
+	"memory.consolidate_at",
+	"encryption.",
+}
+
+// Config returns the configuration in effect, including the node list.
+func (g *Gateway) Config() config.Config {
+	return g.state.Load().config
+}
+
+// Reload switches the gateway to cfg and llm, and re-reads the soul prompt.
+// Replies in progress finish with the previous model. cfg should be
+// validated and unlocked by the caller. Settings that only take effect on
+// restart are not applied; their keys are returned so that the caller can
+// say so.
+func (g *Gateway) Reload(cfg config.Config, llm ai.Client) (restart []string) {
+	old := g.state.Swap(newState(cfg, llm, g.soul))
+
+	before := make(map[string]string)
+	for _, s := range old.config.Settings() {
+		before[s.Key] = s.Value
+	}
+	seen := make(map[string]bool)
+	for _, s := range cfg.Settings() {
+		seen[s.Key] = true
+		if needsRestart(s.Key) && before[s.Key] != s.Value {
+			restart = append(restart, s.Key)
+		}
+	}
+	for key := range before {
+		if !seen[key] && needsRestart(key) {
+			restart = append(restart, key)
+		}
+	}
+	return restart
+}
+
+func needsRestart(key string) bool {
+	for _, k := range restartKeys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchFiles polls paths every interval until ctx is done, and sends on
+// changed whenever one of them is written, replaced, created or removed.
+// Sends do not block, so changes made while the receiver is busy are
+// coalesced into one.
+func WatchFiles(ctx context.Context, interval time.Duration, changed chan<- struct{}, paths ...string) {
+	last := make([]fileStamp, len(paths))
+	for i, p := range paths {
+		last[i] = stat(p)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modified := false
+		for i, p := range paths {
+			if s := stat(p); s != last[i] {
+				last[i] = s
+				modified = true
+			}
+		}
+		if modified {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// fileStamp identifies a version of a file; the zero value is a missing one.
+type fileStamp struct {
+	mtime int64 // Unix nanoseconds
+	size  int64
+}
+
+func stat(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{mtime: fi.ModTime().UnixNano(), size: fi.Size()}
+}
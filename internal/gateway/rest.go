@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fjrt/poeai/internal/memory"
+)
+
+// REST endpoints for inspecting and correcting memories and facts:
+//
+//	GET    /memories?type=&source=&since=&until=&limit=&offset=
+//	GET    /memories/search?q=&limit=
+//	POST   /memories
+//	GET    /memories/{id}
+//	PATCH  /memories/{id}
+//	DELETE /memories/{id}
+//	GET    /facts?prefix=&limit=&offset=
+//	GET    /facts/{key}
+//	PUT    /facts/{key}
+//	DELETE /facts/{key}
+//
+// since and until are RFC 3339 times. Requests from other machines must
+// carry the gateway token as "Authorization: Bearer <token>".
+func (g *Gateway) routeMemory(mux *http.ServeMux) {
+	mux.HandleFunc("GET /memories", g.guard(g.handleListMemories))
+	mux.HandleFunc("GET /memories/search", g.guard(g.handleSearchMemories))
+	mux.HandleFunc("POST /memories", g.guard(g.handleCreateMemory))
+	mux.HandleFunc("GET /memories/{id}", g.guard(g.handleGetMemory))
+	mux.HandleFunc("PATCH /memories/{id}", g.guard(g.handleUpdateMemory))
+	mux.HandleFunc("DELETE /memories/{id}", g.guard(g.handleDeleteMemory))
+	mux.HandleFunc("GET /facts", g.guard(g.handleListFacts))
+	mux.HandleFunc("GET /facts/{key}", g.guard(g.handleGetFact))
+	mux.HandleFunc("PUT /facts/{key}", g.guard(g.handleSetFact))
+	mux.HandleFunc("DELETE /facts/{key}", g.guard(g.handleDeleteFact))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps store errors to HTTP statuses.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, memory.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func parsePage(r *http.Request) (memory.Page, error) {
+	var p memory.Page
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if p.Limit, err = strconv.Atoi(v); err != nil {
+			return p, fmt.Errorf("limit: %w", err)
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil {
+			return p, fmt.Errorf("offset: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (g *Gateway) handleListMemories(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	f := memory.MemoryFilter{Type: memory.MemoryType(q.Get("type")), Source: q.Get("source")}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	mems, err := g.memory.List(r.Context(), f, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(mems))
+}
+
+func (g *Gateway) handleSearchMemories(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit := page.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	mems, err := g.memory.Search(r.Context(), query, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(mems))
+}
+
+func (g *Gateway) handleCreateMemory(w http.ResponseWriter, r *http.Request) {
+	var m memory.Memory
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if m.Content == "" {
+		http.Error(w, "content is required", http.StatusBadRequest)
+		return
+	}
+	if m.Type == "" {
+		m.Type = memory.TypeSemantic
+	}
+	if m.Source == "" {
+		m.Source = "api"
+	}
+	m.ID = "" // assigned by the store
+	id, err := g.memory.Write(r.Context(), m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	created, _, err := g.memory.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (g *Gateway) handleGetMemory(w http.ResponseWriter, r *http.Request) {
+	m, ok, err := g.memory.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, memory.ErrNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (g *Gateway) handleUpdateMemory(w http.ResponseWriter, r *http.Request) {
+	var u memory.MemoryUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err := g.memory.Update(r.Context(), r.PathValue("id"), u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (g *Gateway) handleDeleteMemory(w http.ResponseWriter, r *http.Request) {
+	if err := g.memory.Delete(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) handleListFacts(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	facts, err := g.memory.ListFacts(r.Context(), r.URL.Query().Get("prefix"), page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(facts))
+}
+
+func (g *Gateway) handleGetFact(w http.ResponseWriter, r *http.Request) {
+	f, ok, err := g.memory.Fact(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, memory.ErrNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (g *Gateway) handleSetFact(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Value      string   `json:"value"`
+		Confidence *float64 `json:"confidence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	confidence := 1.0
+	if body.Confidence != nil {
+		confidence = *body.Confidence
+	}
+	key := r.PathValue("key")
+	if err := g.memory.SetFact(r.Context(), key, body.Value, confidence); err != nil {
+		writeError(w, err)
+		return
+	}
+	g.handleGetFact(w, r)
+}
+
+func (g *Gateway) handleDeleteFact(w http.ResponseWriter, r *http.Request) {
+	if err := g.memory.DeleteFact(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nonNil makes empty listings encode as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
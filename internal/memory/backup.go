@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies the database at srcPath to destPath with SQLite's online
+// backup API. The copy is a consistent snapshot even while the gateway is
+// writing to the source; if a writer holds the lock, Backup retries until ctx
+// is done.
+func Backup(ctx context.Context, srcPath, destPath string) error {
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	src, err := sql.Open("sqlite3", "file:"+srcPath+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("backup: open %s: %w", srcPath, err)
+	}
+	defer src.Close()
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("backup: open %s: %w", destPath, err)
+	}
+	defer dest.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer srcConn.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Copy everything in one step so that the snapshot is taken
+				// under a single read lock.
+				done, err := b.Step(-1)
+				if err != nil || done {
+					if ferr := b.Finish(); err == nil {
+						err = ferr
+					}
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					b.Finish()
+					return ctx.Err()
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("backup %s: %w", srcPath, err)
+	}
+	return nil
+}
+
+// FileSchemaVersion returns the schema version of the database file at path
+// without opening it as a Store, which would migrate it. Databases from
+// before versioned migrations report 0.
+func FileSchemaVersion(ctx context.Context, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var tables int
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&tables); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	return schemaVersion(ctx, db)
+}
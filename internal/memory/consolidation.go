@@ -0,0 +1,306 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Op is a consolidation operation, following Mem0's Add/Update/Delete/NOOP.
+type Op string
+
+const (
+	OpAdd    Op = "add"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+	OpNoop   Op = "noop"
+)
+
+// Consolidation targets.
+const (
+	TargetMemory = "memory"
+	TargetFact   = "fact"
+)
+
+// Operation is one change to long-term memory proposed during consolidation.
+// Memory operations address a memory by ID; fact operations by Key.
+type Operation struct {
+	Op         Op         `json:"op"`
+	Target     string     `json:"target"`
+	ID         string     `json:"id,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	Content    string     `json:"content,omitempty"` // memory content or fact value
+	Type       MemoryType `json:"type,omitempty"`
+	Importance float64    `json:"importance,omitempty"`
+	Confidence float64    `json:"confidence,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// Change is an audit log entry for one applied Operation.
+type Change struct {
+	ID        int64     `json:"id"`
+	RunID     string    `json:"run_id"`
+	Op        Op        `json:"op"`
+	Target    string    `json:"target"`
+	Ref       string    `json:"ref"` // memory ID or fact key
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UnconsolidatedEpisodes returns up to limit of the oldest episodic memories
+// that no consolidation run has processed yet.
+func (s *Store) UnconsolidatedEpisodes(ctx context.Context, limit int) ([]Memory, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+memoryColumns+`
+		 FROM memories m LEFT JOIN consolidated_memories c ON c.memory_id = m.id
+		 WHERE m.type = ? AND c.memory_id IS NULL
+		 ORDER BY m.created_at, m.id
+		 LIMIT ?`, string(TypeEpisodic), limit)
+	if err != nil {
+		return nil, fmt.Errorf("unconsolidated episodes: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Memory
+	for rows.Next() {
+		m, err := s.scanMemory(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// SkippedOperation is an operation ApplyConsolidation left out because it
+// was invalid, such as an update of a memory that does not exist.
+type SkippedOperation struct {
+	Index int // position in the ops passed to ApplyConsolidation
+	Op    Operation
+	Err   error
+}
+
+// invalidOpError marks an operation that cannot be applied as given.
+type invalidOpError struct{ msg string }
+
+func (e *invalidOpError) Error() string { return e.msg }
+
+func invalidf(format string, args ...interface{}) error {
+	return &invalidOpError{fmt.Sprintf(format, args...)}
+}
+
+// ApplyConsolidation applies ops and marks the episodic memories in sources
+// as consolidated, all in one transaction. Every change is recorded in the
+// audit log under runID and returned. Invalid operations are skipped and
+// returned with the reason, so that one bad proposal from the model does not
+// hold back the rest; any other failure applies nothing.
+func (s *Store) ApplyConsolidation(ctx context.Context, runID string, sources []string, ops []Operation) ([]Change, []SkippedOperation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var changes []Change
+	var skipped []SkippedOperation
+	var embedIDs, embedContents []string
+	for i, op := range ops {
+		c, err := s.applyOp(ctx, tx, op, now)
+		var invalid *invalidOpError
+		if errors.As(err, &invalid) {
+			skipped = append(skipped, SkippedOperation{Index: i, Op: op, Err: err})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Target, err)
+		}
+		if c == nil {
+			continue
+		}
+		c.RunID = runID
+		c.CreatedAt = now
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO memory_changes (run_id, op, target, ref, before, after, reason, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.RunID, string(c.Op), c.Target, c.Ref, s.sealChange(c.Target, c.Before), s.sealChange(c.Target, c.After),
+			c.Reason, now.Unix())
+		if err != nil {
+			return nil, nil, fmt.Errorf("log change: %w", err)
+		}
+		c.ID, _ = res.LastInsertId()
+		changes = append(changes, *c)
+		if c.Target == TargetMemory && c.Op != OpDelete {
+			embedIDs = append(embedIDs, c.Ref)
+			embedContents = append(embedContents, c.After)
+		}
+	}
+	for _, id := range sources {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO consolidated_memories (memory_id, run_id, consolidated_at) VALUES (?, ?, ?)`,
+			id, runID, now.Unix()); err != nil {
+			return nil, nil, fmt.Errorf("mark consolidated: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	// Stale embeddings were dropped above; re-embed on a best-effort basis.
+	s.embedLater(embedIDs, embedContents)
+	return changes, skipped, nil
+}
+
+// applyOp applies a single operation and describes the change, or returns
+// nil for a no-op. Operations that cannot be applied as given fail with an
+// *invalidOpError before changing anything.
+func (s *Store) applyOp(ctx context.Context, tx *sql.Tx, op Operation, now time.Time) (*Change, error) {
+	c := &Change{Op: op.Op, Target: op.Target, Reason: op.Reason}
+	switch {
+	case op.Op == OpNoop:
+		return nil, nil
+
+	case op.Target == TargetMemory && op.Op == OpAdd:
+		if op.Content == "" {
+			return nil, invalidf("missing content")
+		}
+		switch op.Type {
+		case "":
+			op.Type = TypeSemantic
+		case TypeSemantic, TypeFact, TypeProcedural:
+		default:
+			// Episodes are what consolidation distills, not what it adds.
+			return nil, invalidf("cannot add a memory of type %q", op.Type)
+		}
+		id, err := s.insertMemory(ctx, tx, Memory{
+			Type:       op.Type,
+			Content:    op.Content,
+			Source:     "consolidation",
+			Importance: op.Importance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.Ref, c.After = id, op.Content
+
+	case op.Target == TargetMemory && (op.Op == OpUpdate || op.Op == OpDelete):
+		var before string
+		err := tx.QueryRowContext(ctx, `SELECT content FROM memories WHERE id = ?`, op.ID).Scan(&before)
+		if err == sql.ErrNoRows {
+			return nil, invalidf("memory %s not found", op.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if before, err = s.open(before); err != nil {
+			return nil, fmt.Errorf("memory %s: %w", op.ID, err)
+		}
+		c.Ref, c.Before = op.ID, before
+		if op.Op == OpDelete {
+			if err := deleteMemory(ctx, tx, op.ID); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if op.Content == "" {
+			return nil, invalidf("missing content")
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE memories SET content = ?,
+			     type = COALESCE(NULLIF(?, ''), type),
+			     importance = CASE WHEN ? > 0 THEN ? ELSE importance END
+			 WHERE id = ?`,
+			s.seal(op.Content), string(op.Type), op.Importance, op.Importance, op.ID)
+		if err != nil {
+			return nil, fmt.Errorf("update memory: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM memory_embeddings WHERE memory_id = ?`, op.ID); err != nil {
+			return nil, err
+		}
+		c.After = op.Content
+
+	case op.Target == TargetFact && (op.Op == OpAdd || op.Op == OpUpdate || op.Op == OpDelete):
+		if op.Key == "" {
+			return nil, invalidf("missing key")
+		}
+		c.Ref = op.Key
+		err := tx.QueryRowContext(ctx, `SELECT value FROM facts WHERE key = ?`, op.Key).Scan(&c.Before)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if op.Op == OpDelete {
+			if err == sql.ErrNoRows {
+				return nil, invalidf("fact %s not found", op.Key)
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM facts WHERE key = ?`, op.Key); err != nil {
+				return nil, fmt.Errorf("delete fact: %w", err)
+			}
+			break
+		}
+		if op.Content == "" {
+			return nil, invalidf("missing content")
+		}
+		confidence := op.Confidence
+		if confidence == 0 {
+			confidence = 1.0
+		}
+		if err := setFact(ctx, tx, op.Key, op.Content, confidence, now); err != nil {
+			return nil, err
+		}
+		c.After = op.Content
+
+	default:
+		return nil, invalidf("unsupported operation %s of %s", op.Op, op.Target)
+	}
+	return c, nil
+}
+
+// deleteMemory removes a memory along with its derived rows. Its embedding
+// is deleted by the foreign key.
+func deleteMemory(ctx context.Context, db execer, id string) error {
+	for _, q := range []string{
+		`DELETE FROM memories WHERE id = ?`,
+		`DELETE FROM consolidated_memories WHERE memory_id = ?`,
+	} {
+		if _, err := db.ExecContext(ctx, q, id); err != nil {
+			return fmt.Errorf("delete memory: %w", err)
+		}
+	}
+	return nil
+}
+
+// Changes returns up to limit entries of the consolidation audit log, newest
+// first.
+func (s *Store) Changes(ctx context.Context, limit int) ([]Change, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, run_id, op, target, ref, before, after, reason, created_at
+		 FROM memory_changes ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("changes: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Change
+	for rows.Next() {
+		var c Change
+		var op string
+		var created int64
+		if err := rows.Scan(&c.ID, &c.RunID, &op, &c.Target, &c.Ref, &c.Before, &c.After, &c.Reason, &created); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if c.Before, err = s.open(c.Before); err != nil {
+			return nil, fmt.Errorf("change %d: %w", c.ID, err)
+		}
+		if c.After, err = s.open(c.After); err != nil {
+			return nil, fmt.Errorf("change %d: %w", c.ID, err)
+		}
+		c.Op = Op(op)
+		c.CreatedAt = time.Unix(created, 0)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when updating or deleting a memory or fact that
+// does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Page selects a window of a listing. A zero Limit means DefaultPageSize;
+// limits above MaxPageSize are capped.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+func (p Page) bounds() (limit, offset int) {
+	limit = p.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return limit, max(p.Offset, 0)
+}
+
+// MemoryFilter narrows List. Zero fields match everything; Since and Until
+// bound the creation time, inclusive and exclusive respectively.
+type MemoryFilter struct {
+	Type   MemoryType
+	Source string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Get returns the memory with the given ID and records the access.
+func (s *Store) Get(ctx context.Context, id string) (Memory, bool, error) {
+	m, err := s.scanMemory(s.db.QueryRowContext(ctx,
+		`SELECT `+memoryColumns+` FROM memories m WHERE m.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Memory{}, false, nil
+	}
+	if err != nil {
+		return Memory{}, false, err
+	}
+	now := time.Now()
+	if err := s.touch(ctx, []string{id}, now); err != nil {
+		return Memory{}, false, err
+	}
+	m.AccessedAt = now
+	return m, true, nil
+}
+
+// List returns a page of memories matching f, newest first. Listing does not
+// count as access.
+func (s *Store) List(ctx context.Context, f MemoryFilter, page Page) ([]Memory, error) {
+	var where []string
+	var args []interface{}
+	if f.Type != "" {
+		where = append(where, "m.type = ?")
+		args = append(args, string(f.Type))
+	}
+	if f.Source != "" {
+		where = append(where, "m.source = ?")
+		args = append(args, f.Source)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "m.created_at < ?")
+		args = append(args, f.Until.Unix())
+	}
+	query := `SELECT ` + memoryColumns + ` FROM memories m`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	limit, offset := page.bounds()
+	query += ` ORDER BY m.created_at DESC, m.id LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list memories: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Memory
+	for rows.Next() {
+		m, err := s.scanMemory(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// MemoryUpdate lists the fields Update changes; nil fields are left alone.
+type MemoryUpdate struct {
+	Content    *string                `json:"content,omitempty"`
+	Type       *MemoryType            `json:"type,omitempty"`
+	Importance *float64               `json:"importance,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"` // replaces the metadata when non-nil
+}
+
+// Update changes a memory and returns the result. It returns ErrNotFound if
+// the memory does not exist. A memory whose content changes is re-embedded.
+func (s *Store) Update(ctx context.Context, id string, u MemoryUpdate) (Memory, error) {
+	var set []string
+	var args []interface{}
+	if u.Content != nil {
+		if *u.Content == "" {
+			return Memory{}, fmt.Errorf("update memory: content is empty")
+		}
+		set = append(set, "content = ?")
+		args = append(args, s.seal(*u.Content))
+	}
+	if u.Type != nil {
+		set = append(set, "type = ?")
+		args = append(args, string(*u.Type))
+	}
+	if u.Importance != nil {
+		if *u.Importance < 0 || *u.Importance > 1 {
+			return Memory{}, fmt.Errorf("update memory: importance %v is outside 0..1", *u.Importance)
+		}
+		set = append(set, "importance = ?")
+		args = append(args, *u.Importance)
+	}
+	if u.Metadata != nil {
+		meta, _ := json.Marshal(u.Metadata)
+		set = append(set, "metadata = ?")
+		args = append(args, string(meta))
+	}
+
+	if len(set) > 0 {
+		res, err := s.db.ExecContext(ctx,
+			`UPDATE memories SET `+strings.Join(set, ", ")+` WHERE id = ?`, append(args, id)...)
+		if err != nil {
+			return Memory{}, fmt.Errorf("update memory: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return Memory{}, ErrNotFound
+		}
+	}
+	m, ok, err := s.Get(ctx, id)
+	if err != nil {
+		return Memory{}, err
+	}
+	if !ok {
+		return Memory{}, ErrNotFound
+	}
+	if u.Content != nil {
+		// Drop the stale embedding first so that a failure to embed the new
+		// content leaves the memory to EmbedMissing rather than mis-ranked.
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM memory_embeddings WHERE memory_id = ?`, id); err != nil {
+			return Memory{}, err
+		}
+		s.embedLater([]string{id}, []string{m.Content})
+	}
+	return m, nil
+}
+
+// Delete removes a memory. It returns ErrNotFound if it does not exist.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM memories WHERE id = ?`, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := deleteMemory(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecayPolicy controls how memories fade. Importance halves every HalfLife
+// of its type that passes without the memory being accessed; types without a
+// half-life never decay. Memories whose importance falls below ArchiveBelow
+// are moved to the archive, where they are kept for ArchiveRetention (zero
+// keeps them forever).
+type DecayPolicy struct {
+	HalfLife         map[MemoryType]time.Duration
+	ArchiveBelow     float64
+	ArchiveRetention time.Duration
+}
+
+// DecayResult reports what a Decay run did.
+type DecayResult struct {
+	Decayed  int // memories whose importance was lowered
+	Archived int // memories moved to the archive
+	Purged   int // archived memories deleted for good
+}
+
+const lastDecayKey = "last_decay"
+
+// touch records that memories were accessed at now.
+func (s *Store) touch(ctx context.Context, ids []string, now time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, now.Unix())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE memories SET accessed_at = ? WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("record access: %w", err)
+	}
+	return nil
+}
+
+// Decay applies policy as of now: importance decays for the time since the
+// previous run or the last access, whichever is later, then memories below
+// the threshold are archived and expired archive entries purged.
+func (s *Store) Decay(ctx context.Context, policy DecayPolicy, now time.Time) (DecayResult, error) {
+	var res DecayResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	var lastStr string
+	switch err := tx.QueryRowContext(ctx, `SELECT value FROM store_meta WHERE key = ?`, lastDecayKey).Scan(&lastStr); err {
+	case nil:
+		last, _ = strconv.ParseInt(lastStr, 10, 64)
+	case sql.ErrNoRows:
+	default:
+		return res, err
+	}
+
+	type update struct {
+		id         string
+		importance float64
+	}
+	var updates []update
+	rows, err := tx.QueryContext(ctx, `SELECT id, type, importance, accessed_at FROM memories`)
+	if err != nil {
+		return res, fmt.Errorf("decay: %w", err)
+	}
+	for rows.Next() {
+		var id, typ string
+		var importance float64
+		var accessed int64
+		if err := rows.Scan(&id, &typ, &importance, &accessed); err != nil {
+			rows.Close()
+			return res, fmt.Errorf("scan: %w", err)
+		}
+		halfLife := policy.HalfLife[MemoryType(typ)]
+		if halfLife <= 0 {
+			continue
+		}
+		since := time.Unix(max(last, accessed), 0)
+		elapsed := now.Sub(since)
+		if elapsed <= 0 {
+			continue
+		}
+		updates = append(updates, update{id, importance * math.Exp2(-float64(elapsed)/float64(halfLife))})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	for _, u := range updates {
+		if _, err := tx.ExecContext(ctx, `UPDATE memories SET importance = ? WHERE id = ?`, u.importance, u.id); err != nil {
+			return res, fmt.Errorf("decay: %w", err)
+		}
+	}
+	res.Decayed = len(updates)
+
+	if policy.ArchiveBelow > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO memories_archive
+			     (id, type, content, source, importance, created_at, accessed_at, metadata, archived_at)
+			 SELECT id, type, content, source, importance, created_at, accessed_at, metadata, ?
+			 FROM memories WHERE importance < ?`, now.Unix(), policy.ArchiveBelow); err != nil {
+			return res, fmt.Errorf("archive: %w", err)
+		}
+		ids, err := queryStrings(ctx, tx, `SELECT id FROM memories WHERE importance < ?`, policy.ArchiveBelow)
+		if err != nil {
+			return res, fmt.Errorf("archive: %w", err)
+		}
+		for _, id := range ids {
+			if err := deleteMemory(ctx, tx, id); err != nil {
+				return res, err
+			}
+		}
+		res.Archived = len(ids)
+	}
+
+	if policy.ArchiveRetention > 0 {
+		r, err := tx.ExecContext(ctx, `DELETE FROM memories_archive WHERE archived_at < ?`,
+			now.Add(-policy.ArchiveRetention).Unix())
+		if err != nil {
+			return res, fmt.Errorf("purge archive: %w", err)
+		}
+		n, _ := r.RowsAffected()
+		res.Purged = int(n)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO store_meta (key, value) VALUES (?, ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		lastDecayKey, strconv.FormatInt(now.Unix(), 10)); err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
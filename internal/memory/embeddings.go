@@ -0,0 +1,256 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var vecDrivers sync.Map // extension path → registered driver name
+
+// driverName returns the database/sql driver that loads the sqlite-vec
+// extension at path into every connection, registering it on first use.
+func driverName(path string) string {
+	if path == "" {
+		return "sqlite3"
+	}
+	name := "sqlite3_vec:" + path
+	if _, loaded := vecDrivers.LoadOrStore(path, name); !loaded {
+		sql.Register(name, &sqlite3.SQLiteDriver{
+			ConnectHook: func(c *sqlite3.SQLiteConn) error {
+				// A missing extension is not fatal: Open probes for
+				// vec_version() and falls back to computing in Go.
+				c.LoadExtension(path, "sqlite3_vec_init")
+				return nil
+			},
+		})
+	}
+	return name
+}
+
+// embedTimeout bounds embedding in the background. What does not finish in
+// time is left to EmbedMissing.
+const embedTimeout = 10 * time.Second
+
+// embedLater embeds the given memories in the background, so that writes do
+// not wait for a slow or unreachable embedding server. Memories it fails to
+// embed are picked up by EmbedMissing.
+func (s *Store) embedLater(ids, contents []string) {
+	if s.embedder == nil || len(ids) == 0 {
+		return
+	}
+	s.embedding.Add(1)
+	go func() {
+		defer s.embedding.Done()
+		ctx, cancel := context.WithTimeout(s.embedCtx, embedTimeout)
+		defer cancel()
+		s.embed(ctx, ids, contents)
+	}()
+}
+
+// embed computes and stores embeddings for the given memories. A memory that
+// was deleted or given other content while its embedding was computed is
+// skipped, so that an embedding finishing late never replaces a newer one.
+func (s *Store) embed(ctx context.Context, ids, contents []string) error {
+	if s.embedder == nil || len(ids) == 0 {
+		return nil
+	}
+	vecs, err := s.embedder.Embed(ctx, contents)
+	if err != nil {
+		return fmt.Errorf("embed: %w", err)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, id := range ids {
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT content FROM memories WHERE id = ?`, id).Scan(&current)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("store embedding: %w", err)
+		}
+		if current, err = s.open(current); err != nil || current != contents[i] {
+			continue
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO memory_embeddings (memory_id, model, dim, vector) VALUES (?, ?, ?, ?)
+			 ON CONFLICT(memory_id) DO UPDATE SET model=excluded.model, dim=excluded.dim, vector=excluded.vector`,
+			id, s.embedder.Model(), len(vecs[i]), encodeVector(vecs[i]))
+		if err != nil {
+			return fmt.Errorf("store embedding: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// EmbedMissing embeds memories that have no embedding from the configured
+// model yet, e.g. ones written while the embedding server was down or before
+// the model was changed. It returns the number of memories embedded.
+func (s *Store) EmbedMissing(ctx context.Context) (int, error) {
+	if s.embedder == nil {
+		return 0, nil
+	}
+	const batch = 32
+	total := 0
+	for {
+		rows, err := s.db.QueryContext(ctx,
+			`SELECT m.id, m.content FROM memories m
+			 LEFT JOIN memory_embeddings e ON e.memory_id = m.id AND e.model = ?
+			 WHERE e.memory_id IS NULL LIMIT ?`, s.embedder.Model(), batch)
+		if err != nil {
+			return total, fmt.Errorf("find unembedded memories: %w", err)
+		}
+		var ids, contents []string
+		for rows.Next() {
+			var id, content string
+			if err := rows.Scan(&id, &content); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("scan: %w", err)
+			}
+			if content, err = s.open(content); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("memory %s: %w", id, err)
+			}
+			ids = append(ids, id)
+			contents = append(contents, content)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		if err := s.embed(ctx, ids, contents); err != nil {
+			return total, err
+		}
+		total += len(ids)
+	}
+}
+
+// DefaultMinSimilarity is the cosine similarity below which a memory is not
+// taken as related to a query. Embedding models place unrelated texts well
+// above zero, so a floor keeps them out of results.
+const DefaultMinSimilarity = 0.45
+
+// searchSimilar returns up to limit embedded memories most similar to query,
+// scored by cosine similarity, leaving out those below the store's minimum.
+func (s *Store) searchSimilar(ctx context.Context, query string, limit int) ([]scored, error) {
+	vecs, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	q := vecs[0]
+	var all []scored
+	if s.vec {
+		all, err = s.searchSimilarVec(ctx, q, limit)
+	} else {
+		all, err = s.searchSimilarGo(ctx, q, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0]
+	for _, c := range all {
+		if c.score >= s.minSim {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// searchSimilarGo is searchSimilar with the similarity computed in Go.
+func (s *Store) searchSimilarGo(ctx context.Context, q []float32, limit int) ([]scored, error) {
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+memoryColumns+`, e.vector
+		 FROM memory_embeddings e JOIN memories m ON m.id = e.memory_id
+		 WHERE e.model = ? AND e.dim = ?`, s.embedder.Model(), len(q))
+	if err != nil {
+		return nil, fmt.Errorf("similar: %w", err)
+	}
+	defer rows.Close()
+
+	var all []scored
+	for rows.Next() {
+		var blob []byte
+		m, err := s.scanMemory(rows, &blob)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, scored{m, cosine(q, decodeVector(blob))})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].score > all[j].score })
+	if len(all) > limit {
+		all = all[:limit]
+	}
+	return all, nil
+}
+
+// searchSimilarVec is searchSimilar with the distance computed by sqlite-vec.
+func (s *Store) searchSimilarVec(ctx context.Context, q []float32, limit int) ([]scored, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+memoryColumns+`, vec_distance_cosine(e.vector, ?) AS distance
+		 FROM memory_embeddings e JOIN memories m ON m.id = e.memory_id
+		 WHERE e.model = ? AND e.dim = ?
+		 ORDER BY distance LIMIT ?`,
+		encodeVector(q), s.embedder.Model(), len(q), limit)
+	if err != nil {
+		return nil, fmt.Errorf("similar: %w", err)
+	}
+	defer rows.Close()
+
+	var out []scored
+	for rows.Next() {
+		var distance float64
+		m, err := s.scanMemory(rows, &distance)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, scored{m, 1 - distance})
+	}
+	return out, rows.Err()
+}
+
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
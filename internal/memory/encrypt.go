@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fjrt/poeai/internal/crypt"
+)
+
+// ErrLocked is returned by Open for an encrypted database opened without a
+// cipher.
+var ErrLocked = errors.New("memory database is encrypted; a passphrase or key file is required")
+
+// cipherCheckKey is the store_meta key of a value sealed with the database
+// key, present once the database is encrypted.
+const cipherCheckKey = "cipher_check"
+
+// dropFTSSQL removes the full-text index, which would hold memory contents
+// in plaintext.
+const dropFTSSQL = dropFTSTriggersSQL + `
+DROP TABLE IF EXISTS memories_fts;`
+
+const dropFTSTriggersSQL = `
+DROP TRIGGER IF EXISTS memories_fts_insert;
+DROP TRIGGER IF EXISTS memories_fts_delete;
+DROP TRIGGER IF EXISTS memories_fts_update;`
+
+// unlock checks c against the key the database is encrypted with, and
+// encrypts a database that is not encrypted yet.
+func (s *Store) unlock(ctx context.Context, c *crypt.Cipher) error {
+	var check string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM store_meta WHERE key = ?`, cipherCheckKey).Scan(&check)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if c == nil {
+			return nil
+		}
+		return s.Rekey(ctx, c)
+	case err != nil:
+		return err
+	case c == nil:
+		return ErrLocked
+	}
+	if err := c.Verify(check); err != nil {
+		return err
+	}
+	s.cipher = c
+	return nil
+}
+
+// Rekey re-encrypts all memory contents, including archived memories and the
+// consolidation audit log, and session transcripts and titles with c, or
+// decrypts them if c is nil. The store must not be in use otherwise. Freed
+// pages that still hold the old contents are discarded by vacuuming the
+// database.
+func (s *Store) Rekey(ctx context.Context, c *crypt.Cipher) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, col := range []struct{ table, key, column, where string }{
+		{"memories", "id", "content", ""},
+		{"memories_archive", "id", "content", ""},
+		{"memory_changes", "id", "before", "target = '" + TargetMemory + "'"},
+		{"memory_changes", "id", "after", "target = '" + TargetMemory + "'"},
+		{"session_messages", "id", "content", ""},
+		{"session_messages", "id", "tool_calls", ""},
+		{"sessions", "id", "title", ""},
+	} {
+		if err := s.rekeyColumn(ctx, tx, c, col.table, col.key, col.column, col.where); err != nil {
+			return fmt.Errorf("rekey %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	if c == nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM store_meta WHERE key = ?`, cipherCheckKey)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO store_meta (key, value) VALUES (?, ?)
+			 ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+			cipherCheckKey, c.CheckValue())
+	}
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		if _, err := tx.ExecContext(ctx, dropFTSSQL); err != nil {
+			return fmt.Errorf("drop full-text index: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.cipher = c
+	s.fts = false
+	if c == nil {
+		s.db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&s.fts)
+	}
+	if s.fts {
+		if _, err := s.db.ExecContext(ctx, ftsSQL); err != nil {
+			return fmt.Errorf("init full-text index: %w", err)
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) rekeyColumn(ctx context.Context, tx *sql.Tx, c *crypt.Cipher, table, key, column, where string) error {
+	query := `SELECT ` + key + `, ` + column + ` FROM ` + table
+	if where != "" {
+		query += ` WHERE ` + where
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		key   interface{}
+		value string
+	}
+	var updates []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.value); err != nil {
+			rows.Close()
+			return err
+		}
+		if r.value == "" {
+			continue
+		}
+		plain, err := s.open(r.value)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("%v: %w", r.key, err)
+		}
+		if c == nil {
+			r.value = plain
+		} else {
+			r.value = c.Seal(plain)
+		}
+		updates = append(updates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range updates {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+table+` SET `+column+` = ? WHERE `+key+` = ?`, r.value, r.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seal encrypts a memory content or session text for storage.
+func (s *Store) seal(content string) string {
+	if s.cipher == nil {
+		return content
+	}
+	return s.cipher.Seal(content)
+}
+
+// sealText seals a value that is empty when absent, such as a session title,
+// leaving it empty.
+func (s *Store) sealText(value string) string {
+	if value == "" {
+		return value
+	}
+	return s.seal(value)
+}
+
+// sealChange seals the before or after value of an audit log entry, which
+// holds a memory content for memory targets.
+func (s *Store) sealChange(target, value string) string {
+	if target != TargetMemory {
+		return value
+	}
+	return s.sealText(value)
+}
+
+// open decrypts a stored memory content or session text. Plaintext, as
+// written before the database was encrypted, is returned unchanged.
+func (s *Store) open(content string) (string, error) {
+	if s.cipher == nil {
+		if crypt.IsSealed(content) {
+			return "", ErrLocked
+		}
+		return content, nil
+	}
+	return s.cipher.Open(content)
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Record is one line of a JSONL export: a memory or a fact.
+type Record struct {
+	Kind   string  `json:"kind"` // TargetMemory or TargetFact
+	Memory *Memory `json:"memory,omitempty"`
+	Fact   *Fact   `json:"fact,omitempty"`
+}
+
+// ImportResult counts what Import did.
+type ImportResult struct {
+	Memories int // memories added
+	Facts    int // facts added or replaced by newer values
+	Skipped  int // records already present, or older than what the store has
+}
+
+// Export writes all memories, oldest first, followed by all facts to w as
+// JSON lines. Embeddings are not exported; they are recomputed on import.
+// It returns the number of records written.
+func (s *Store) Export(ctx context.Context, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+memoryColumns+` FROM memories m ORDER BY m.created_at, m.id`)
+	if err != nil {
+		return 0, fmt.Errorf("export memories: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := s.scanMemory(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(Record{Kind: TargetMemory, Memory: &m}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `SELECT key, value, confidence, updated_at FROM facts ORDER BY key`)
+	if err != nil {
+		return n, fmt.Errorf("export facts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f Fact
+		var updated int64
+		if err := rows.Scan(&f.Key, &f.Value, &f.Confidence, &updated); err != nil {
+			return n, fmt.Errorf("scan: %w", err)
+		}
+		f.UpdatedAt = time.Unix(updated, 0)
+		if err := enc.Encode(Record{Kind: TargetFact, Fact: &f}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// Import reads records written by Export from r, in one transaction.
+// Memories keep their IDs and timestamps and are skipped if a memory with the
+// same ID exists. A fact replaces the stored one only if it was updated more
+// recently.
+func (s *Store) Import(ctx context.Context, r io.Reader) (ImportResult, error) {
+	var res ImportResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	var embedIDs, embedContents []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return res, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch {
+		case rec.Kind == TargetMemory && rec.Memory != nil:
+			m := *rec.Memory
+			if m.ID == "" || m.Content == "" {
+				return res, fmt.Errorf("line %d: memory without id or content", line)
+			}
+			var exists int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM memories WHERE id = ?`, m.ID).Scan(&exists); err != nil {
+				return res, err
+			}
+			if exists > 0 {
+				res.Skipped++
+				continue
+			}
+			if _, err := s.insertMemory(ctx, tx, m); err != nil {
+				return res, fmt.Errorf("line %d: %w", line, err)
+			}
+			embedIDs = append(embedIDs, m.ID)
+			embedContents = append(embedContents, m.Content)
+			res.Memories++
+
+		case rec.Kind == TargetFact && rec.Fact != nil:
+			f := *rec.Fact
+			if f.Key == "" {
+				return res, fmt.Errorf("line %d: fact without key", line)
+			}
+			var updated int64
+			err := tx.QueryRowContext(ctx, `SELECT updated_at FROM facts WHERE key = ?`, f.Key).Scan(&updated)
+			if err != nil && err != sql.ErrNoRows {
+				return res, err
+			}
+			if err == nil && updated >= f.UpdatedAt.Unix() {
+				res.Skipped++
+				continue
+			}
+			if err := setFact(ctx, tx, f.Key, f.Value, f.Confidence, f.UpdatedAt); err != nil {
+				return res, fmt.Errorf("line %d: %w", line, err)
+			}
+			res.Facts++
+
+		default:
+			return res, fmt.Errorf("line %d: unknown record kind %q", line, rec.Kind)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+
+	// Import is a batch job rather than a request, so it waits for the
+	// embeddings. Memories that cannot be embedded now are picked up by
+	// EmbedMissing.
+	s.embed(ctx, embedIDs, embedContents)
+	return res, nil
+}
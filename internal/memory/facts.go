@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Fact is an entry of Poe's world model.
+type Fact struct {
+	Key        string    `json:"key"`
+	Value      string    `json:"value"`
+	Confidence float64   `json:"confidence"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Fact returns the fact stored under key.
+func (s *Store) Fact(ctx context.Context, key string) (Fact, bool, error) {
+	f := Fact{Key: key}
+	var updated int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT value, confidence, updated_at FROM facts WHERE key = ?`, key).
+		Scan(&f.Value, &f.Confidence, &updated)
+	if err == sql.ErrNoRows {
+		return Fact{}, false, nil
+	}
+	if err != nil {
+		return Fact{}, false, err
+	}
+	f.UpdatedAt = time.Unix(updated, 0)
+	return f, true, nil
+}
+
+// ListFacts returns a page of facts whose key starts with prefix, ordered by
+// key.
+func (s *Store) ListFacts(ctx context.Context, prefix string, page Page) ([]Fact, error) {
+	limit, offset := page.bounds()
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT key, value, confidence, updated_at FROM facts
+		 WHERE substr(key, 1, length(?)) = ?
+		 ORDER BY key LIMIT ? OFFSET ?`, prefix, prefix, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("list facts: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Fact
+	for rows.Next() {
+		var f Fact
+		var updated int64
+		if err := rows.Scan(&f.Key, &f.Value, &f.Confidence, &updated); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		f.UpdatedAt = time.Unix(updated, 0)
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// DeleteFact removes the fact stored under key. It returns ErrNotFound if
+// there is none.
+func (s *Store) DeleteFact(ctx context.Context, key string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM facts WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("delete fact: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+-- Full-text index over memories.content, kept in sync by triggers. Applied
+-- only when SQLite is built with FTS5 (go build -tags sqlite_fts5); without
+-- it, Open removes the triggers.
+CREATE VIRTUAL TABLE IF NOT EXISTS memories_fts USING fts5(
+    id UNINDEXED,
+    content,
+    tokenize = 'porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS memories_fts_insert AFTER INSERT ON memories BEGIN
+    INSERT INTO memories_fts (id, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS memories_fts_delete AFTER DELETE ON memories BEGIN
+    DELETE FROM memories_fts WHERE id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS memories_fts_update AFTER UPDATE OF content ON memories BEGIN
+    DELETE FROM memories_fts WHERE id = old.id;
+    INSERT INTO memories_fts (id, content) VALUES (new.id, new.content);
+END;
+
+-- Index memories written before the table existed.
+INSERT INTO memories_fts (id, content)
+SELECT id, content FROM memories WHERE id NOT IN (SELECT id FROM memories_fts);
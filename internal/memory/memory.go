@@ -6,14 +6,18 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/crypt"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-//go:embed schema.sql
-var schemaSQL string
+//go:embed fts.sql
+var ftsSQL string
 
 type MemoryType string
 
@@ -33,31 +37,153 @@ type Memory struct {
 	CreatedAt  time.Time              `json:"created_at"`
 	AccessedAt time.Time              `json:"accessed_at"`
 	Metadata   map[string]interface{} `json:"metadata"`
+
+	// Set by Search only.
+	Snippet string  `json:"snippet,omitempty"` // matched excerpt, matches wrapped in **
+	Score   float64 `json:"score,omitempty"`   // hybrid rank, higher is better
 }
 
 type Store struct {
-	db *sql.DB
+	db       *sql.DB
+	embedder ai.Embedder
+	vec      bool // sqlite-vec is loaded; similarity is computed in SQL
+	fts      bool // memories_fts exists; text search uses FTS5 and BM25
+	ranking  Ranking
+	minSim   float64 // similarity below which memories are not related
+	cipher   *crypt.Cipher
+
+	embedCtx  context.Context // canceled by Close to stop background embedding
+	stopEmbed context.CancelFunc
+	embedding sync.WaitGroup
+}
+
+// Options configures optional Store features.
+type Options struct {
+	// Embedder enables semantic search. Without one, Search matches text.
+	Embedder ai.Embedder
+	// VecExtension is the path of the sqlite-vec loadable extension. When it
+	// is empty or cannot be loaded, similarity is computed in Go.
+	VecExtension string
+	// Ranking weighs search results. The zero value means DefaultRanking.
+	Ranking Ranking
+	// MinSimilarity is the cosine similarity to a query below which
+	// memories are not semantic matches. Zero means DefaultMinSimilarity;
+	// a negative value keeps every match.
+	MinSimilarity float64
+	// Cipher encrypts memory contents and session transcripts and titles at
+	// rest. A database that is not yet encrypted is encrypted on open.
+	// Full-text indexing is unavailable for encrypted databases; text search
+	// then decrypts and scans memories. Facts, which are looked up by key,
+	// and embeddings, which are compared in SQL, are not encrypted.
+	Cipher *crypt.Cipher
 }
 
 // Open opens a SQLite database at the given path.
 func Open(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	return OpenWith(dbPath, Options{})
+}
+
+// OpenWith opens a SQLite database at the given path with opts.
+func OpenWith(dbPath string, opts Options) (*Store, error) {
+	// Foreign keys are off by default in SQLite; the schema relies on them to
+	// delete a memory's embedding and a session's messages with it.
+	dsn := dbPath + "?_foreign_keys=1"
+	if strings.Contains(dbPath, "?") {
+		dsn = dbPath + "&_foreign_keys=1"
+	}
+	db, err := sql.Open(driverName(opts.VecExtension), dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
+	if dbPath == ":memory:" {
+		// Every connection to ":memory:" gets its own empty database.
+		db.SetMaxOpenConns(1)
+	}
 
-	if _, err := db.Exec(schemaSQL); err != nil {
-		return nil, fmt.Errorf("init schema: %w", err)
+	if err := migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %s: %w", dbPath, err)
 	}
 
-	return &Store{db: db}, nil
+	s := &Store{db: db, embedder: opts.Embedder, ranking: opts.Ranking}
+	s.embedCtx, s.stopEmbed = context.WithCancel(context.Background())
+	if s.ranking == (Ranking{}) {
+		s.ranking = DefaultRanking
+	}
+	if s.minSim = opts.MinSimilarity; s.minSim == 0 {
+		s.minSim = DefaultMinSimilarity
+	}
+	if err := s.unlock(context.Background(), opts.Cipher); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %w", dbPath, err)
+	}
+	s.vec = db.QueryRow(`SELECT vec_version()`).Scan(new(string)) == nil
+	db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&s.fts)
+	if s.cipher != nil {
+		s.fts = false
+	}
+	if err := s.initFTS(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init full-text index: %w", err)
+	}
+	return s, nil
 }
 
+// initFTS creates the full-text index if FTS5 is available. Otherwise it
+// removes the index's triggers, left by a binary built with FTS5, as they
+// would fail every write. An index whose triggers are missing is rebuilt,
+// since it lacks the writes made meanwhile.
+func (s *Store) initFTS(ctx context.Context) error {
+	if !s.fts {
+		if _, err := s.db.ExecContext(ctx, dropFTSTriggersSQL); err != nil {
+			return err
+		}
+		// Without the FTS5 module the table may not be droppable; it is
+		// unused and rebuilt when FTS5 is back.
+		s.db.ExecContext(ctx, `DROP TABLE IF EXISTS memories_fts`)
+		return nil
+	}
+	var triggers int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('memories_fts_insert', 'memories_fts_delete', 'memories_fts_update')`,
+	).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if triggers < 3 {
+		if _, err := s.db.ExecContext(ctx, dropFTSSQL); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(ctx, ftsSQL)
+	return err
+}
+
+// Close stops background embedding and closes the database.
 func (s *Store) Close() error {
+	s.stopEmbed()
+	s.embedding.Wait()
 	return s.db.Close()
 }
 
 func (s *Store) Write(ctx context.Context, mem Memory) (string, error) {
+	id, err := s.insertMemory(ctx, s.db, mem)
+	if err != nil {
+		return "", err
+	}
+	// A memory that is not embedded yet is still found by text search.
+	s.embedLater([]string{id}, []string{mem.Content})
+
+	return id, nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertMemory fills in defaults for mem and inserts it, returning its ID.
+func (s *Store) insertMemory(ctx context.Context, db execer, mem Memory) (string, error) {
 	if mem.ID == "" {
 		mem.ID = uuid.New().String()
 	}
@@ -78,54 +204,47 @@ func (s *Store) Write(ctx context.Context, mem Memory) (string, error) {
 
 	query := `INSERT INTO memories (id, type, content, source, importance, created_at, accessed_at, metadata)
 	          VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err := s.db.ExecContext(ctx, query,
-		mem.ID, string(mem.Type), mem.Content, mem.Source, mem.Importance,
+	_, err := db.ExecContext(ctx, query,
+		mem.ID, string(mem.Type), s.seal(mem.Content), mem.Source, mem.Importance,
 		mem.CreatedAt.Unix(), mem.AccessedAt.Unix(), string(metaJSON))
 	if err != nil {
 		return "", fmt.Errorf("insert memory: %w", err)
 	}
-
 	return mem.ID, nil
 }
 
-func (s *Store) Search(ctx context.Context, query string, limit int) ([]Memory, error) {
-	// Simple LIKE search for v1 (Task 2). Task 5 will add sqlite-vec.
-	sqlQuery := `SELECT id, type, content, source, importance, created_at, accessed_at, metadata
-	             FROM memories
-	             WHERE content LIKE ?
-	             ORDER BY importance DESC, accessed_at DESC
-	             LIMIT ?`
+// memoryColumns selects the fields scanMemory reads from memories aliased as m.
+const memoryColumns = `m.id, m.type, m.content, m.source, m.importance, m.created_at, m.accessed_at, m.metadata`
 
-	rows, err := s.db.QueryContext(ctx, sqlQuery, "%"+query+"%", limit)
+func (s *Store) scanMemory(row interface{ Scan(...interface{}) error }, extra ...interface{}) (Memory, error) {
+	var m Memory
+	var mType, metaStr string
+	var created, accessed int64
+	dest := append([]interface{}{&m.ID, &mType, &m.Content, &m.Source, &m.Importance, &created, &accessed, &metaStr}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Memory{}, fmt.Errorf("scan: %w", err)
+	}
+	content, err := s.open(m.Content)
 	if err != nil {
-		return nil, fmt.Errorf("search: %w", err)
-	}
-	defer rows.Close()
-
-	var results []Memory
-	for rows.Next() {
-		var m Memory
-		var mType, metaStr string
-		var created, accessed int64
-		err := rows.Scan(&m.ID, &mType, &m.Content, &m.Source, &m.Importance, &created, &accessed, &metaStr)
-		if err != nil {
-			return nil, fmt.Errorf("scan: %w", err)
-		}
-		m.Type = MemoryType(mType)
-		m.CreatedAt = time.Unix(created, 0)
-		m.AccessedAt = time.Unix(accessed, 0)
-		json.Unmarshal([]byte(metaStr), &m.Metadata)
-		results = append(results, m)
+		return Memory{}, fmt.Errorf("memory %s: %w", m.ID, err)
 	}
-
-	return results, nil
+	m.Content = content
+	m.Type = MemoryType(mType)
+	m.CreatedAt = time.Unix(created, 0)
+	m.AccessedAt = time.Unix(accessed, 0)
+	json.Unmarshal([]byte(metaStr), &m.Metadata)
+	return m, nil
 }
 
 func (s *Store) SetFact(ctx context.Context, key, value string, confidence float64) error {
+	return setFact(ctx, s.db, key, value, confidence, time.Now())
+}
+
+func setFact(ctx context.Context, db execer, key, value string, confidence float64, now time.Time) error {
 	query := `INSERT INTO facts (key, value, confidence, updated_at) 
 	          VALUES (?, ?, ?, ?)
 	          ON CONFLICT(key) DO UPDATE SET value=excluded.value, confidence=excluded.confidence, updated_at=excluded.updated_at`
-	_, err := s.db.ExecContext(ctx, query, key, value, confidence, time.Now().Unix())
+	_, err := db.ExecContext(ctx, query, key, value, confidence, now.Unix())
 	return err
 }
 
@@ -2,8 +2,17 @@ package memory_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/fjrt/poeai/internal/crypt"
 	"github.com/fjrt/poeai/internal/memory"
 )
 
@@ -33,6 +42,318 @@ func TestStore_WriteAndSearch(t *testing.T) {
 	}
 }
 
+// A database indexed by a binary built with FTS5 stays writable in one built
+// without it, and its index is rebuilt when FTS5 is back.
+func TestStore_FTSAcrossBuilds(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "poe.db")
+	store, err := memory.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	id, _ := store.Write(ctx, memory.Memory{Content: "the NAS runs TrueNAS", Type: memory.TypeSemantic})
+	store.Close()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	var fts bool
+	db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts)
+	var setup []string
+	if fts {
+		// What a binary without FTS5 leaves behind: an index that missed
+		// the writes made without its triggers.
+		setup = []string{
+			`DROP TRIGGER memories_fts_insert`,
+			`DROP TRIGGER memories_fts_delete`,
+			`DROP TRIGGER memories_fts_update`,
+			`UPDATE memories SET content = 'the NAS runs Unraid' WHERE id = '` + id + `'`,
+		}
+	} else {
+		// What a binary with FTS5 leaves behind: an index this one cannot
+		// use, and a trigger writing to it.
+		setup = []string{
+			`PRAGMA writable_schema = ON`,
+			`INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql) VALUES ('table', 'memories_fts', 'memories_fts', 0,
+			 'CREATE VIRTUAL TABLE memories_fts USING fts5(id UNINDEXED, content)')`,
+			`PRAGMA writable_schema = OFF`,
+			`CREATE TRIGGER memories_fts_insert AFTER INSERT ON memories BEGIN
+			 INSERT INTO memories_fts (id, content) VALUES (new.id, new.content); END`,
+		}
+	}
+	for _, q := range setup {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	db.Close()
+
+	store, err = memory.Open(path)
+	if err != nil {
+		t.Fatalf("reopen: Open() error = %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Write(ctx, memory.Memory{Content: "the pi runs Home Assistant", Type: memory.TypeSemantic}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if res, err := store.Search(ctx, "Home Assistant", 5); err != nil || len(res) != 1 {
+		t.Errorf("Search(Home Assistant) = %+v, %v; want the new memory", res, err)
+	}
+	if fts {
+		if res, err := store.Search(ctx, "Unraid", 5); err != nil || len(res) != 1 || res[0].ID != id {
+			t.Errorf("Search(Unraid) = %+v, %v; want the memory updated without the index", res, err)
+		}
+		if res, _ := store.Search(ctx, "TrueNAS", 5); len(res) != 0 {
+			t.Errorf("Search(TrueNAS) = %+v; want no stale index entries", res)
+		}
+	}
+}
+
+func TestStore_SearchRanking(t *testing.T) {
+	store, _ := memory.Open(":memory:")
+	defer store.Close()
+	ctx := context.Background()
+
+	store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Importance: 0.9,
+		Content: "the thermostat is important"})
+	store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Importance: 0.5,
+		Content: "the ESPHome thermostat reports to Home Assistant on ha-server"})
+	store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test",
+		Content: "ha-server runs Debian 12"})
+	store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test",
+		Content: "fjrt prefers dark roast coffee"})
+
+	// Neither word order nor adjacency matters; the memory mentioning both
+	// words ranks first despite its lower importance.
+	results, err := store.Search(ctx, "ha-server thermostat", 5)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Search() returned %d results, want 3: %+v", len(results), results)
+	}
+	if !strings.Contains(results[0].Content, "ESPHome") {
+		t.Errorf("top result = %q, want the one matching both words", results[0].Content)
+	}
+	if !strings.Contains(results[0].Snippet, "**thermostat**") {
+		t.Errorf("snippet = %q, want thermostat highlighted", results[0].Snippet)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score > results[i-1].Score {
+			t.Errorf("results not sorted by score: %v > %v", results[i].Score, results[i-1].Score)
+		}
+	}
+}
+
+func TestStore_SemanticSearch(t *testing.T) {
+	store, err := memory.OpenWith(":memory:", memory.Options{Embedder: ai.HashEmbedder{Dim: 64}})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	for _, c := range []string{
+		"fjrt prefers dark roast coffee",
+		"the ESPHome thermostat node lives in the hallway",
+		"backups run nightly on the NAS",
+	} {
+		if _, err := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: c, Source: "test"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	// Writes are embedded in the background; catch up on any still missing.
+	if _, err := store.EmbedMissing(ctx); err != nil {
+		t.Fatalf("EmbedMissing() error = %v", err)
+	}
+
+	// No memory contains the query as a substring, so only similarity finds it.
+	results, err := store.Search(ctx, "where is the thermostat", 1)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Content, "thermostat") {
+		t.Errorf("Search() = %+v, want the thermostat memory", results)
+	}
+	if n, err := store.EmbedMissing(ctx); err != nil || n != 0 {
+		t.Errorf("EmbedMissing() = %d, %v; want nothing to do", n, err)
+	}
+
+	// A query related to no memory finds none, rather than the least
+	// dissimilar ones.
+	if results, err := store.Search(ctx, "quantum chromodynamics lecture", 3); err != nil || len(results) != 0 {
+		t.Errorf("Search(unrelated) = %+v, %v; want nothing", results, err)
+	}
+	all, err := memory.OpenWith(":memory:", memory.Options{Embedder: ai.HashEmbedder{Dim: 64}, MinSimilarity: -1})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	defer all.Close()
+	all.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: "backups run nightly on the NAS"})
+	all.EmbedMissing(ctx)
+	if results, _ := all.Search(ctx, "quantum chromodynamics lecture", 3); len(results) != 1 {
+		t.Errorf("Search(unrelated) with no minimum = %+v, want every memory", results)
+	}
+}
+
+// stuckEmbedder never answers, like an embedding server that hangs.
+type stuckEmbedder struct{}
+
+func (stuckEmbedder) Model() string { return "stuck" }
+
+func (stuckEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStore_WriteDoesNotWaitForEmbedder(t *testing.T) {
+	store, err := memory.OpenWith(":memory:", memory.Options{Embedder: stuckEmbedder{}})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() {
+		id, err := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: "the NAS runs TrueNAS"})
+		if err == nil {
+			content := "the NAS runs Unraid"
+			_, err = store.Update(ctx, id, memory.MemoryUpdate{Content: &content})
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Write() or Update() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write() or Update() waited for the embedding server")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() waited for the embedding server")
+	}
+}
+
+// heldEmbedder is a HashEmbedder that does not answer for the texts in hold
+// until release is closed, like a slow embedding server.
+type heldEmbedder struct {
+	ai.HashEmbedder
+	hold    map[string]bool
+	release chan struct{}
+}
+
+func (e heldEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.hold[texts[0]] {
+		<-e.release
+	}
+	return e.HashEmbedder.Embed(ctx, texts)
+}
+
+func TestStore_LateEmbeddingIsDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "poe.db")
+	emb := heldEmbedder{
+		HashEmbedder: ai.HashEmbedder{Dim: 16},
+		hold:         map[string]bool{"the NAS runs TrueNAS": true, "the printer is in the attic": true},
+		release:      make(chan struct{}),
+	}
+	store, err := memory.OpenWith(path, memory.Options{Embedder: emb})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// The embedding of the first content finishes after that of the second.
+	updated, err := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: "the NAS runs TrueNAS"})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	content := "the NAS runs Unraid"
+	if _, err := store.Update(ctx, updated, memory.MemoryUpdate{Content: &content}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	var want []byte
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		err := db.QueryRow(`SELECT vector FROM memory_embeddings WHERE memory_id = ?`, updated).Scan(&want)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows || time.Now().After(deadline) {
+			t.Fatalf("embedding of the updated memory: %v", err)
+		}
+	}
+
+	// A memory is deleted before its embedding finishes.
+	deleted, err := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: "the printer is in the attic"})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := store.Delete(ctx, deleted); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	// Close would cancel the held embeddings, so they are given a while to
+	// store what they computed.
+	close(emb.release)
+	defer store.Close()
+	for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var got []byte
+		if err := db.QueryRow(`SELECT vector FROM memory_embeddings WHERE memory_id = ?`, updated).Scan(&got); err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("embedding of the updated memory was replaced by that of its old content (err = %v)", err)
+		}
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM memory_embeddings WHERE memory_id = ?`, deleted).Scan(&n)
+		if n != 0 {
+			t.Fatalf("deleted memory has %d embeddings, want 0", n)
+		}
+	}
+}
+
+func TestStore_DeleteCascadesToEmbedding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "poe.db")
+	store, err := memory.OpenWith(path, memory.Options{Embedder: ai.HashEmbedder{Dim: 16}})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	id, err := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Content: "the NAS runs TrueNAS"})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := store.EmbedMissing(ctx); err != nil {
+		t.Fatalf("EmbedMissing() error = %v", err)
+	}
+	if err := store.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM memory_embeddings`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("embeddings left after Delete() = %d, %v; want 0", n, err)
+	}
+}
+
 func TestStore_Facts(t *testing.T) {
 	store, _ := memory.Open(":memory:")
 	defer store.Close()
@@ -46,3 +367,261 @@ func TestStore_Facts(t *testing.T) {
 		t.Errorf("GetFact() = %q, %v, %v", val, ok, err)
 	}
 }
+
+func TestStore_Sessions(t *testing.T) {
+	store, _ := memory.Open(":memory:")
+	defer store.Close()
+	ctx := context.Background()
+
+	sess, err := store.CreateSession(ctx)
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	err = store.AppendMessages(ctx, sess.ID,
+		ai.Message{Role: ai.RoleUser, Content: "Is ha-server up?"},
+		ai.Message{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{{ID: "call_1", Name: "memory_search", Arguments: map[string]interface{}{"query": "ha-server"}}}},
+		ai.Message{Role: ai.RoleTool, ToolCallID: "call_1", Content: "No relevant memories found."},
+		ai.Message{Role: ai.RoleAssistant, Content: "It is."},
+	)
+	if err != nil {
+		t.Fatalf("AppendMessages() error = %v", err)
+	}
+
+	recent, err := store.RecentMessages(ctx, sess.ID, 3)
+	if err != nil {
+		t.Fatalf("RecentMessages() error = %v", err)
+	}
+	if len(recent) != 3 || recent[0].ToolCalls[0].Name != "memory_search" || recent[2].Content != "It is." {
+		t.Errorf("RecentMessages() = %+v", recent)
+	}
+
+	got, ok, err := store.GetSession(ctx, sess.ID)
+	if err != nil || !ok || got.Title != "Is ha-server up?" {
+		t.Errorf("GetSession() = %+v, %v, %v", got, ok, err)
+	}
+}
+
+func TestStore_ApplyConsolidationSkipsInvalidOps(t *testing.T) {
+	store, _ := memory.Open(":memory:")
+	defer store.Close()
+	ctx := context.Background()
+
+	ep, _ := store.Write(ctx, memory.Memory{Type: memory.TypeEpisodic, Source: "test", Content: "fjrt bought a NAS"})
+	changes, skipped, err := store.ApplyConsolidation(ctx, "run-1", []string{ep}, []memory.Operation{
+		{Op: memory.OpAdd, Target: memory.TargetFact, Key: "homelab.nas", Content: "present"},
+		{Op: memory.OpDelete, Target: memory.TargetFact, Key: "does.not.exist"},
+		{Op: memory.OpUpdate, Target: memory.TargetMemory, ID: "does-not-exist", Content: "x"},
+		{Op: memory.OpAdd, Target: memory.TargetMemory, Type: memory.TypeEpisodic, Content: "an invented episode"},
+		{Op: "merge", Target: memory.TargetMemory},
+	})
+	if err != nil {
+		t.Fatalf("ApplyConsolidation() error = %v", err)
+	}
+	if len(changes) != 1 || changes[0].Ref != "homelab.nas" {
+		t.Errorf("changes = %+v, want the fact only", changes)
+	}
+	var idx []int
+	for _, sk := range skipped {
+		idx = append(idx, sk.Index)
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(idx, want) {
+		t.Errorf("skipped operations %v, want %v", idx, want)
+	}
+	if v, ok, _ := store.GetFact(ctx, "homelab.nas"); !ok || v != "present" {
+		t.Errorf("GetFact() = %q, %v; want the valid operation applied", v, ok)
+	}
+	if eps, _ := store.UnconsolidatedEpisodes(ctx, 10); len(eps) != 0 {
+		t.Errorf("UnconsolidatedEpisodes() = %d, want the episode consolidated", len(eps))
+	}
+	if eps, _ := store.List(ctx, memory.MemoryFilter{Type: memory.TypeEpisodic}, memory.Page{Limit: 10}); len(eps) != 1 {
+		t.Errorf("episodic memories = %+v, want only the original", eps)
+	}
+}
+
+func TestStore_DecayAndArchive(t *testing.T) {
+	store, _ := memory.Open(":memory:")
+	defer store.Close()
+	ctx := context.Background()
+
+	const day = 24 * time.Hour
+	now := time.Now()
+	policy := memory.DecayPolicy{
+		HalfLife:     map[memory.MemoryType]time.Duration{memory.TypeEpisodic: 30 * day},
+		ArchiveBelow: 0.05,
+	}
+	ep, _ := store.Write(ctx, memory.Memory{Type: memory.TypeEpisodic, Source: "test", Content: "fjrt rebooted the router"})
+	sem, _ := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "the router is a MikroTik"})
+
+	if res, err := store.Decay(ctx, policy, now.Add(30*day)); err != nil || res.Decayed != 1 {
+		t.Fatalf("Decay() = %+v, %v; want 1 decayed", res, err)
+	}
+	m, ok, _ := store.Get(ctx, ep)
+	if !ok || m.Importance < 0.24 || m.Importance > 0.26 {
+		t.Errorf("importance after one half-life = %v, want 0.25", m.Importance)
+	}
+
+	// Get recorded an access at the real now, so only the time since the
+	// previous run counts: three more half-lives bring it under the threshold.
+	res, err := store.Decay(ctx, policy, now.Add(120*day))
+	if err != nil || res.Archived != 1 {
+		t.Fatalf("Decay() = %+v, %v; want 1 archived", res, err)
+	}
+	if _, ok, _ := store.Get(ctx, ep); ok {
+		t.Error("archived memory is still live")
+	}
+	if m, ok, _ := store.Get(ctx, sem); !ok || m.Importance != 0.5 {
+		t.Errorf("semantic memory = %+v, %v; want untouched", m, ok)
+	}
+}
+
+func TestStore_CRUD(t *testing.T) {
+	store, _ := memory.Open(":memory:")
+	defer store.Close()
+	ctx := context.Background()
+
+	for i, typ := range []memory.MemoryType{memory.TypeEpisodic, memory.TypeSemantic, memory.TypeEpisodic} {
+		store.Write(ctx, memory.Memory{Type: typ, Source: "test", Content: "memory " + string(rune('a'+i)),
+			CreatedAt: time.Unix(int64(1000+i), 0)})
+	}
+	eps, err := store.List(ctx, memory.MemoryFilter{Type: memory.TypeEpisodic}, memory.Page{Limit: 1})
+	if err != nil || len(eps) != 1 || eps[0].Content != "memory c" {
+		t.Fatalf("List(episodic, limit 1) = %+v, %v; want newest episode", eps, err)
+	}
+	next, _ := store.List(ctx, memory.MemoryFilter{Type: memory.TypeEpisodic}, memory.Page{Limit: 1, Offset: 1})
+	if len(next) != 1 || next[0].Content != "memory a" {
+		t.Errorf("second page = %+v, want memory a", next)
+	}
+
+	content, importance := "corrected", 0.9
+	m, err := store.Update(ctx, eps[0].ID, memory.MemoryUpdate{Content: &content, Importance: &importance})
+	if err != nil || m.Content != "corrected" || m.Importance != 0.9 || m.Type != memory.TypeEpisodic {
+		t.Errorf("Update() = %+v, %v", m, err)
+	}
+	if err := store.Delete(ctx, eps[0].ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, eps[0].ID); !errors.Is(err, memory.ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Update(ctx, "nope", memory.MemoryUpdate{Content: &content}); !errors.Is(err, memory.ErrNotFound) {
+		t.Errorf("Update(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	store.SetFact(ctx, "homelab.nas.os", "TrueNAS", 0.8)
+	store.SetFact(ctx, "owner.name", "fjrt", 1)
+	facts, err := store.ListFacts(ctx, "homelab.", memory.Page{})
+	if err != nil || len(facts) != 1 || facts[0].Value != "TrueNAS" {
+		t.Errorf("ListFacts(homelab.) = %+v, %v", facts, err)
+	}
+	if err := store.DeleteFact(ctx, "owner.name"); err != nil {
+		t.Errorf("DeleteFact() error = %v", err)
+	}
+	if _, ok, _ := store.Fact(ctx, "owner.name"); ok {
+		t.Error("deleted fact still present")
+	}
+}
+
+func TestStore_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	src, _ := memory.Open(":memory:")
+	defer src.Close()
+	id, _ := src.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "ha-server runs Debian 12",
+		Metadata: map[string]interface{}{"node": "ha-server"}})
+	src.SetFact(ctx, "homelab.ha-server.os", "Debian 12", 0.9)
+
+	var buf strings.Builder
+	n, err := src.Export(ctx, &buf)
+	if err != nil || n != 2 {
+		t.Fatalf("Export() = %d, %v; want 2 records", n, err)
+	}
+
+	dst, _ := memory.Open(":memory:")
+	defer dst.Close()
+	res, err := dst.Import(ctx, strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if res.Memories != 1 || res.Facts != 1 || res.Skipped != 0 {
+		t.Errorf("Import() = %+v, want 1 memory and 1 fact", res)
+	}
+	m, ok, err := dst.Get(ctx, id)
+	if err != nil || !ok || m.Content != "ha-server runs Debian 12" || m.Metadata["node"] != "ha-server" {
+		t.Errorf("imported memory = %+v, %v, %v", m, ok, err)
+	}
+	if v, _, _ := dst.GetFact(ctx, "homelab.ha-server.os"); v != "Debian 12" {
+		t.Errorf("imported fact = %q", v)
+	}
+
+	// Importing again changes nothing.
+	res, err = dst.Import(ctx, strings.NewReader(buf.String()))
+	if err != nil || res.Memories != 0 || res.Facts != 0 || res.Skipped != 2 {
+		t.Errorf("second Import() = %+v, %v; want everything skipped", res, err)
+	}
+}
+
+func TestStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "poe.db")
+
+	// A plaintext database is encrypted when first opened with a key.
+	plain, _ := memory.Open(path)
+	id, _ := plain.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "the NAS is called tardis"})
+	sess, _ := plain.CreateSession(ctx)
+	plain.AppendMessages(ctx, sess.ID,
+		ai.Message{Role: ai.RoleUser, Content: "what is the printer called? skaro?"},
+		ai.Message{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{{ID: "call_a", Name: "memory_search", Arguments: map[string]interface{}{"query": "dalek"}}}})
+	plain.Close()
+
+	key, _, _ := crypt.NewKey([]byte("correct horse"))
+	store, err := memory.OpenWith(path, memory.Options{Cipher: key})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "the router is called gallifrey"})
+	results, err := store.Search(ctx, "tardis", 5)
+	if err != nil || len(results) != 1 || results[0].ID != id {
+		t.Errorf("Search() = %+v, %v; want the decrypted memory", results, err)
+	}
+	store.AppendMessages(ctx, sess.ID, ai.Message{Role: ai.RoleTool, ToolCallID: "call_a", Content: "the printer is mondas"})
+	msgs, err := store.RecentMessages(ctx, sess.ID, 10)
+	if err != nil || len(msgs) != 3 || msgs[0].Content != "what is the printer called? skaro?" ||
+		msgs[1].ToolCalls[0].Arguments["query"] != "dalek" || msgs[2].Content != "the printer is mondas" {
+		t.Errorf("RecentMessages() = %+v, %v; want the decrypted transcript", msgs, err)
+	}
+	if got, _, err := store.GetSession(ctx, sess.ID); err != nil || got.Title != "what is the printer called? skaro?" {
+		t.Errorf("GetSession() = %+v, %v; want the decrypted title", got, err)
+	}
+	store.Close()
+
+	raw, _ := os.ReadFile(path)
+	for _, word := range []string{"tardis", "gallifrey", "skaro", "dalek", "mondas"} {
+		if strings.Contains(string(raw), word) {
+			t.Errorf("database file holds %q in plaintext", word)
+		}
+	}
+	if _, err := memory.Open(path); !errors.Is(err, memory.ErrLocked) {
+		t.Errorf("Open() without key error = %v, want ErrLocked", err)
+	}
+	other, _, _ := crypt.NewKey([]byte("battery staple"))
+	if _, err := memory.OpenWith(path, memory.Options{Cipher: other}); !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("OpenWith(wrong key) error = %v, want ErrWrongKey", err)
+	}
+
+	// Rekeying to no key decrypts everything again.
+	store, _ = memory.OpenWith(path, memory.Options{Cipher: key})
+	if err := store.Rekey(ctx, nil); err != nil {
+		t.Fatalf("Rekey(nil) error = %v", err)
+	}
+	store.Close()
+	store, err = memory.Open(path)
+	if err != nil {
+		t.Fatalf("Open() after decrypting error = %v", err)
+	}
+	defer store.Close()
+	if m, ok, _ := store.Get(ctx, id); !ok || m.Content != "the NAS is called tardis" {
+		t.Errorf("Get() = %+v, %v", m, ok)
+	}
+	if sessions, err := store.ListSessions(ctx, 10); err != nil || len(sessions) != 1 || sessions[0].Title != "what is the printer called? skaro?" {
+		t.Errorf("ListSessions() = %+v, %v", sessions, err)
+	}
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are numbered SQL files, NNNN_description.sql, applied in order
+// on Open. Each runs in its own transaction together with the schema_version
+// row recording it. Never edit a migration that has shipped; add a new one.
+//
+// The tables from before versioning was introduced are created with IF NOT
+// EXISTS, so that databases from those builds, which have no schema_version
+// table, upgrade in place.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned by Open for a database migrated by a newer
+// build of Poe.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build of Poe")
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+var migrations = mustLoadMigrations()
+
+func mustLoadMigrations() []migration {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	var out []migration
+	for _, e := range entries {
+		num, _, ok := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("memory: migration %s is not named NNNN_description.sql", e.Name()))
+		}
+		buf, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, migration{version: version, name: e.Name(), sql: string(buf)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	for i, m := range out {
+		if m.version != i+1 {
+			panic(fmt.Sprintf("memory: migration %s out of sequence, want version %d", m.name, i+1))
+		}
+	}
+	return out
+}
+
+// LatestSchemaVersion is the schema version this build migrates databases to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the version the database is migrated to.
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, s.db)
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var v int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v)
+	return v, err
+}
+
+// migrate brings db up to LatestSchemaVersion.
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, this build supports up to %d",
+			ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations[current:] {
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_version (version, applied_at) VALUES (?, ?)`, m.version, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
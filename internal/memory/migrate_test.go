@@ -0,0 +1,86 @@
+package memory_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/memory"
+)
+
+// baselineDB creates a database file from testdata/baseline.sql.
+func baselineDB(t *testing.T) string {
+	t.Helper()
+	fixture, err := os.ReadFile("testdata/baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "poe.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(fixture)); err != nil {
+		t.Fatalf("load fixture: %v", err)
+	}
+	return path
+}
+
+func TestOpen_UpgradesBaseline(t *testing.T) {
+	path := baselineDB(t)
+	ctx := context.Background()
+
+	store, err := memory.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if v, err := store.SchemaVersion(ctx); err != nil || v != memory.LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", v, err, memory.LatestSchemaVersion())
+	}
+
+	// Existing data survives and works with the new features.
+	if m, ok, err := store.Get(ctx, "m2"); err != nil || !ok || m.Metadata["node"] != "ha-server" {
+		t.Errorf("Get(m2) = %+v, %v, %v", m, ok, err)
+	}
+	if results, err := store.Search(ctx, "thermostat", 5); err != nil || len(results) != 1 {
+		t.Errorf("Search() = %+v, %v; want the fixture memory", results, err)
+	}
+	if v, ok, _ := store.GetFact(ctx, "homelab.ha-server.os"); !ok || v != "Debian 12" {
+		t.Errorf("GetFact() = %q, %v", v, ok)
+	}
+	if _, err := store.CreateSession(ctx); err != nil {
+		t.Errorf("CreateSession() error = %v", err)
+	}
+	store.Close()
+
+	// Reopening is a no-op.
+	store, err = memory.Open(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	store.Close()
+}
+
+func TestOpen_RefusesNewerSchema(t *testing.T) {
+	path := baselineDB(t)
+	store, err := memory.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	store.Close()
+
+	db, _ := sql.Open("sqlite3", path)
+	if _, err := db.Exec(`INSERT INTO schema_version (version, applied_at) VALUES (?, 0)`,
+		memory.LatestSchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := memory.Open(path); !errors.Is(err, memory.ErrSchemaTooNew) {
+		t.Errorf("Open() error = %v, want ErrSchemaTooNew", err)
+	}
+}
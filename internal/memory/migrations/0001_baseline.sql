@@ -1,3 +1,4 @@
+-- The original memories and facts tables.
 CREATE TABLE IF NOT EXISTS memories (
     id          TEXT PRIMARY KEY,
     type        TEXT NOT NULL,
@@ -0,0 +1,7 @@
+-- Vectors for semantic search.
+CREATE TABLE IF NOT EXISTS memory_embeddings (
+    memory_id   TEXT PRIMARY KEY REFERENCES memories(id) ON DELETE CASCADE,
+    model       TEXT NOT NULL,
+    dim         INTEGER NOT NULL,
+    vector      BLOB NOT NULL -- little-endian float32, the layout sqlite-vec reads
+);
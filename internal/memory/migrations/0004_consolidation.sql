@@ -0,0 +1,19 @@
+-- Episodic memories already folded into long-term memory by consolidation.
+CREATE TABLE IF NOT EXISTS consolidated_memories (
+    memory_id       TEXT PRIMARY KEY,
+    run_id          TEXT NOT NULL,
+    consolidated_at INTEGER NOT NULL
+);
+
+-- Audit log of every change consolidation made to memories and facts.
+CREATE TABLE IF NOT EXISTS memory_changes (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id      TEXT NOT NULL,
+    op          TEXT NOT NULL,
+    target      TEXT NOT NULL,
+    ref         TEXT NOT NULL, -- memory ID or fact key
+    before      TEXT NOT NULL DEFAULT '',
+    after       TEXT NOT NULL DEFAULT '',
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  INTEGER NOT NULL
+);
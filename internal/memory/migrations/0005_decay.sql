@@ -0,0 +1,18 @@
+-- Memories whose importance decayed below the archive threshold.
+CREATE TABLE IF NOT EXISTS memories_archive (
+    id          TEXT PRIMARY KEY,
+    type        TEXT NOT NULL,
+    content     TEXT NOT NULL,
+    source      TEXT NOT NULL,
+    importance  REAL NOT NULL,
+    created_at  INTEGER NOT NULL,
+    accessed_at INTEGER NOT NULL,
+    metadata    TEXT NOT NULL DEFAULT '{}',
+    archived_at INTEGER NOT NULL
+);
+
+-- Store-wide bookkeeping, e.g. when decay last ran.
+CREATE TABLE IF NOT EXISTS store_meta (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
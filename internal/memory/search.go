@@ -0,0 +1,263 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Ranking weighs the signals Search blends into a memory's score. Relevance
+// is the better of the keyword and semantic match, normalized to 0..1;
+// recency halves every HalfLife since the memory was last accessed.
+type Ranking struct {
+	Relevance  float64
+	Importance float64
+	Recency    float64
+	HalfLife   time.Duration
+}
+
+var DefaultRanking = Ranking{
+	Relevance:  0.6,
+	Importance: 0.25,
+	Recency:    0.15,
+	HalfLife:   30 * 24 * time.Hour,
+}
+
+func (r Ranking) score(relevance float64, m Memory, now time.Time) float64 {
+	recency := 1.0
+	if age := now.Sub(m.AccessedAt); age > 0 && r.HalfLife > 0 {
+		recency = math.Exp2(-float64(age) / float64(r.HalfLife))
+	}
+	return r.Relevance*relevance + r.Importance*m.Importance + r.Recency*recency
+}
+
+// candidateFactor is how many candidates per requested result Search fetches
+// from each source before ranking them.
+const candidateFactor = 4
+
+// scored is a search candidate with its relevance to the query.
+type scored struct {
+	mem   Memory
+	score float64
+}
+
+// Search returns up to limit memories relevant to query, best first. Keyword
+// matches come from the FTS5 index ranked by BM25, or from substring matching
+// of the query's words when SQLite lacks FTS5. With an embedder configured,
+// semantically similar memories are candidates too. Candidates are ranked by
+// the store's Ranking and returned with Score set, and Snippet for keyword
+// matches. Returned memories are recorded as accessed.
+func (s *Store) Search(ctx context.Context, query string, limit int) ([]Memory, error) {
+	results, err := s.Related(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(results))
+	for i, m := range results {
+		ids[i] = m.ID
+	}
+	if err := s.touch(ctx, ids, time.Now()); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Related is Search without recording access, for housekeeping such as
+// consolidation that should not keep memories from decaying.
+func (s *Store) Related(ctx context.Context, query string, limit int) ([]Memory, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	n := limit * candidateFactor
+
+	var cands []scored
+	index := make(map[string]int)
+	add := func(list []scored) {
+		for _, c := range list {
+			i, ok := index[c.mem.ID]
+			if !ok {
+				index[c.mem.ID] = len(cands)
+				cands = append(cands, c)
+				continue
+			}
+			if c.mem.Snippet != "" {
+				cands[i].mem.Snippet = c.mem.Snippet
+			}
+			cands[i].score = math.Max(cands[i].score, c.score)
+		}
+	}
+
+	if s.embedder != nil {
+		// Fall back to keyword matches alone if the query cannot be embedded.
+		if similar, err := s.searchSimilar(ctx, query, n); err == nil {
+			add(similar)
+		}
+	}
+	matches, err := s.searchText(ctx, query, n)
+	if err != nil {
+		return nil, err
+	}
+	add(matches)
+
+	now := time.Now()
+	results := make([]Memory, len(cands))
+	for i, c := range cands {
+		results[i] = c.mem
+		results[i].Score = s.ranking.score(math.Max(c.score, 0), c.mem, now)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// searchText returns up to limit memories matching any word of query, with
+// relevance normalized so the best match scores 1.
+func (s *Store) searchText(ctx context.Context, query string, limit int) ([]scored, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if s.fts {
+		return s.searchFTS(ctx, terms, limit)
+	}
+	return s.searchLike(ctx, terms, limit)
+}
+
+func (s *Store) searchFTS(ctx context.Context, terms []string, limit int) ([]scored, error) {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+memoryColumns+`, bm25(memories_fts), snippet(memories_fts, 1, '**', '**', '…', 12)
+		 FROM memories_fts JOIN memories m ON m.id = memories_fts.id
+		 WHERE memories_fts MATCH ?
+		 ORDER BY bm25(memories_fts)
+		 LIMIT ?`, strings.Join(quoted, " OR "), limit)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	var out []scored
+	for rows.Next() {
+		var rank float64
+		var snippet string
+		m, err := s.scanMemory(rows, &rank, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		m.Snippet = snippet
+		out = append(out, scored{m, -rank}) // bm25() is lower for better matches
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) > 0 && out[0].score > 0 {
+		best := out[0].score
+		for i := range out {
+			out[i].score /= best
+		}
+	}
+	return out, nil
+}
+
+// searchLike is searchFTS for SQLite builds without FTS5 and for encrypted
+// stores. Relevance is the fraction of query words a memory contains.
+// Encrypted contents cannot be matched in SQL, so then every memory is
+// decrypted and matched here.
+func (s *Store) searchLike(ctx context.Context, terms []string, limit int) ([]scored, error) {
+	query := `SELECT ` + memoryColumns + ` FROM memories m`
+	var args []interface{}
+	if s.cipher == nil {
+		where := make([]string, len(terms))
+		for i, t := range terms {
+			where[i] = `m.content LIKE ?`
+			args = append(args, "%"+t+"%")
+		}
+		query += ` WHERE ` + strings.Join(where, " OR ")
+	}
+	query += ` ORDER BY m.importance DESC, m.accessed_at DESC`
+	if s.cipher == nil {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	var out []scored
+	for rows.Next() {
+		m, err := s.scanMemory(rows)
+		if err != nil {
+			return nil, err
+		}
+		lower := strings.ToLower(m.Content)
+		hits := 0
+		for _, t := range terms {
+			if strings.Contains(lower, strings.ToLower(t)) {
+				hits++
+			}
+		}
+		if hits == 0 {
+			continue
+		}
+		m.Snippet = highlight(m.Content, terms)
+		out = append(out, scored{m, float64(hits) / float64(len(terms))})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// queryTerms splits a search query into words, dropping surrounding
+// punctuation but keeping inner punctuation as in "ha-server".
+func queryTerms(query string) []string {
+	var terms []string
+	for _, f := range strings.Fields(query) {
+		f = strings.TrimFunc(f, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+		if f != "" {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// highlight wraps case-insensitive occurrences of terms in content with **,
+// as snippet() does for FTS5 matches.
+func highlight(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	if len(lower) != len(content) {
+		return content // case folding changed byte offsets
+	}
+	var sb strings.Builder
+	for i := 0; i < len(content); {
+		matched := 0
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], strings.ToLower(t)) && len(t) > matched {
+				matched = len(t)
+			}
+		}
+		if matched == 0 {
+			sb.WriteByte(content[i])
+			i++
+			continue
+		}
+		sb.WriteString("**" + content[i:i+matched] + "**")
+		i += matched
+	}
+	return sb.String()
+}
@@ -0,0 +1,160 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fjrt/poeai/internal/ai"
+	"github.com/google/uuid"
+)
+
+// Session is a persisted conversation transcript.
+type Session struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// titleLength caps the title derived from a session's first user message.
+const titleLength = 60
+
+func (s *Store) CreateSession(ctx context.Context) (Session, error) {
+	now := time.Now()
+	sess := Session{ID: uuid.New().String(), CreatedAt: now, UpdatedAt: now}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO sessions (id, title, created_at, updated_at) VALUES (?, '', ?, ?)`,
+		sess.ID, now.Unix(), now.Unix())
+	if err != nil {
+		return Session{}, fmt.Errorf("create session: %w", err)
+	}
+	return sess, nil
+}
+
+func (s *Store) GetSession(ctx context.Context, id string) (Session, bool, error) {
+	var sess Session
+	var created, updated int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, title, created_at, updated_at FROM sessions WHERE id = ?`, id).
+		Scan(&sess.ID, &sess.Title, &created, &updated)
+	if err == sql.ErrNoRows {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	if sess.Title, err = s.open(sess.Title); err != nil {
+		return Session{}, false, fmt.Errorf("session %s: %w", id, err)
+	}
+	sess.CreatedAt = time.Unix(created, 0)
+	sess.UpdatedAt = time.Unix(updated, 0)
+	return sess, true, nil
+}
+
+// ListSessions returns the most recently active sessions first.
+func (s *Store) ListSessions(ctx context.Context, limit int) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, title, created_at, updated_at FROM sessions ORDER BY updated_at DESC, created_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Session
+	for rows.Next() {
+		var sess Session
+		var created, updated int64
+		if err := rows.Scan(&sess.ID, &sess.Title, &created, &updated); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		title, err := s.open(sess.Title)
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %w", sess.ID, err)
+		}
+		sess.Title = title
+		sess.CreatedAt = time.Unix(created, 0)
+		sess.UpdatedAt = time.Unix(updated, 0)
+		out = append(out, sess)
+	}
+	return out, rows.Err()
+}
+
+// AppendMessages adds messages to the end of a session's transcript. The
+// session is titled after its first user message.
+func (s *Store) AppendMessages(ctx context.Context, sessionID string, msgs ...ai.Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	for _, m := range msgs {
+		var calls []byte
+		if len(m.ToolCalls) > 0 {
+			calls, _ = json.Marshal(m.ToolCalls)
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO session_messages (session_id, role, content, tool_calls, tool_call_id, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?)`,
+			sessionID, m.Role, s.sealText(m.Content), s.sealText(string(calls)), m.ToolCallID, now)
+		if err != nil {
+			return fmt.Errorf("append message: %w", err)
+		}
+		if m.Role == ai.RoleUser {
+			title := m.Content
+			if r := []rune(title); len(r) > titleLength {
+				title = string(r[:titleLength]) + "…"
+			}
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE sessions SET title = ? WHERE id = ? AND title = ''`, s.sealText(title), sessionID); err != nil {
+				return fmt.Errorf("title session: %w", err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET updated_at = ? WHERE id = ?`, now, sessionID); err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecentMessages returns up to limit of the latest messages in a session, in
+// chronological order.
+func (s *Store) RecentMessages(ctx context.Context, sessionID string, limit int) ([]ai.Message, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT role, content, tool_calls, tool_call_id FROM (
+		     SELECT id, role, content, tool_calls, tool_call_id FROM session_messages
+		     WHERE session_id = ? ORDER BY id DESC LIMIT ?
+		 ) ORDER BY id ASC`, sessionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("recent messages: %w", err)
+	}
+	defer rows.Close()
+
+	var out []ai.Message
+	for rows.Next() {
+		var m ai.Message
+		var calls string
+		if err := rows.Scan(&m.Role, &m.Content, &calls, &m.ToolCallID); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		var err error
+		if m.Content, err = s.open(m.Content); err != nil {
+			return nil, fmt.Errorf("session %s: %w", sessionID, err)
+		}
+		if calls, err = s.open(calls); err != nil {
+			return nil, fmt.Errorf("session %s: %w", sessionID, err)
+		}
+		if calls != "" {
+			if err := json.Unmarshal([]byte(calls), &m.ToolCalls); err != nil {
+				return nil, fmt.Errorf("decode tool calls: %w", err)
+			}
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
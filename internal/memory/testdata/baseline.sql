@@ -0,0 +1,24 @@
+-- A database as created by the first release, before schema versioning.
+CREATE TABLE IF NOT EXISTS memories (
+    id          TEXT PRIMARY KEY,
+    type        TEXT NOT NULL,
+    content     TEXT NOT NULL,
+    source      TEXT NOT NULL,
+    importance  REAL NOT NULL DEFAULT 0.5,
+    created_at  INTEGER NOT NULL,
+    accessed_at INTEGER NOT NULL,
+    metadata    TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS facts (
+    key         TEXT PRIMARY KEY,
+    value       TEXT NOT NULL,
+    confidence  REAL NOT NULL DEFAULT 1.0,
+    updated_at  INTEGER NOT NULL
+);
+
+INSERT INTO memories VALUES
+    ('m1', 'episodic', 'fjrt fixed the ESPHome thermostat node', 'conversation', 0.7, 1771459200, 1771459200, '{}'),
+    ('m2', 'semantic', 'ha-server runs Home Assistant', 'conversation', 0.5, 1771459200, 1771459200, '{"node":"ha-server"}');
+
+INSERT INTO facts VALUES ('homelab.ha-server.os', 'Debian 12', 1.0, 1771459200);
@@ -1,9 +1,8 @@
 package onboarding
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
@@ -22,8 +21,9 @@ var (
 			Italic(true)
 )
 
-// Onboard starts the onboarding process and returns the final configuration.
-func Onboard() (config.Config, error) {
+// Onboard starts the onboarding process, saves the configuration to path
+// and returns it.
+func Onboard(path string) (config.Config, error) {
 	fmt.Println(headerStyle.Render("WELCOME TO THE RAVEN HOTEL"))
 	fmt.Println(poeStyle.Render("“I am Poe, and I shall be your companion through this initialization.”"))
 	fmt.Println()
@@ -34,7 +34,7 @@ func Onboard() (config.Config, error) {
 		return cfg, err
 	}
 
-	err = saveConfig(cfg)
+	err = saveConfig(cfg, path)
 	if err != nil {
 		return cfg, err
 	}
@@ -46,18 +46,20 @@ func Onboard() (config.Config, error) {
 	return cfg, nil
 }
 
-// Configure allows updating specific sections of the configuration like OpenClaw.
-func Configure() (config.Config, error) {
+// Configure allows updating specific sections of the configuration at path
+// like OpenClaw.
+func Configure(path string) (config.Config, error) {
 	fmt.Println(headerStyle.Render("POE CONFIGURATION"))
 	fmt.Println(poeStyle.Render("“Ah, you wish to make some adjustments. Let us proceed.”"))
 	fmt.Println()
 
-	home, _ := os.UserHomeDir()
-	configPath := filepath.Join(home, ".poe", "config.toml")
-	cfg, err := config.Load(configPath)
+	cfg, err := config.Load(path)
 	if err != nil {
 		cfg, _ = config.Load("") // start a new one if not found
 	}
+	if err := Unlock(&cfg); err != nil {
+		return cfg, err
+	}
 
 	var action string
 	for {
@@ -90,7 +92,7 @@ func Configure() (config.Config, error) {
 				return cfg, err
 			}
 		case "exit":
-			err = saveConfig(cfg)
+			err = saveConfig(cfg, path)
 			if err != nil {
 				return cfg, err
 			}
@@ -102,15 +104,78 @@ func Configure() (config.Config, error) {
 	}
 }
 
-func saveConfig(cfg config.Config) error {
-	home, _ := os.UserHomeDir()
-	configPath := filepath.Join(home, ".poe", "config.toml")
-	if err := cfg.Save(configPath); err != nil {
+func saveConfig(cfg config.Config, path string) error {
+	if err := cfg.Save(path); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 	return nil
 }
 
+// Unlock decrypts an encrypted configuration with its key file or
+// $POE_PASSPHRASE, asking for the passphrase if neither is available.
+func Unlock(cfg *config.Config) error {
+	if !cfg.Encryption.Enabled() {
+		return nil
+	}
+	secret, err := cfg.Encryption.Secret()
+	if errors.Is(err, config.ErrNoPassphrase) {
+		var passphrase string
+		err = huh.NewInput().
+			Title("Passphrase").
+			Description("Poe's stack is encrypted.").
+			EchoMode(huh.EchoModePassword).
+			Value(&passphrase).
+			Run()
+		secret = []byte(passphrase)
+	}
+	if err != nil {
+		return err
+	}
+	return cfg.Unlock(secret)
+}
+
+// KeyPassphrase asks for the passphrase of an SSH private key; it suits
+// ssh.Options.Passphrase.
+func KeyPassphrase(keyPath string) ([]byte, error) {
+	var passphrase string
+	err := huh.NewInput().
+		Title("Passphrase for " + keyPath).
+		EchoMode(huh.EchoModePassword).
+		Value(&passphrase).
+		Run()
+	return []byte(passphrase), err
+}
+
+// NewPassphrase asks for a new passphrase twice.
+func NewPassphrase() (string, error) {
+	var passphrase, confirm string
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("New passphrase").
+				EchoMode(huh.EchoModePassword).
+				Value(&passphrase).
+				Validate(func(s string) error {
+					if len(s) < 8 {
+						return fmt.Errorf("use at least 8 characters")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Title("Repeat the passphrase").
+				EchoMode(huh.EchoModePassword).
+				Value(&confirm).
+				Validate(func(s string) error {
+					if s != passphrase {
+						return fmt.Errorf("the passphrases differ")
+					}
+					return nil
+				}),
+		),
+	).Run()
+	return passphrase, err
+}
+
 func runGatewayWizard(cfg *config.Config) error {
 	var portStr string = fmt.Sprintf("%d", cfg.Gateway.Port)
 
@@ -217,8 +282,8 @@ func runLLMWizard(cfg *config.Config) error {
 
 	groups := []*huh.Group{}
 
-	// Strategy Selection (if not ollama)
-	if selectedProviderID != "ollama" {
+	// Strategy Selection (if not a local provider)
+	if !provider.Local {
 		groups = append(groups, huh.NewGroup(
 			huh.NewSelect[string]().
 				Title("Authentication Strategy").
@@ -238,7 +303,7 @@ func runLLMWizard(cfg *config.Config) error {
 	}
 
 	authGroups := []*huh.Group{}
-	if selectedProviderID != "ollama" {
+	if !provider.Local {
 		if authStrategy == "apikey" {
 			authGroups = append(authGroups, huh.NewGroup(
 				huh.NewInput().
@@ -293,7 +358,7 @@ func runLLMWizard(cfg *config.Config) error {
 	}
 
 	// For local providers, wipe clear irrelevant tokens
-	if selectedProviderID == "ollama" {
+	if provider.Local {
 		authData.Strategy = "none"
 		authData.APIKey = ""
 		authData.Token = ""
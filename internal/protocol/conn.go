@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn sends and receives envelopes over a WebSocket. Send is safe for
+// concurrent use; Receive must be called from a single goroutine.
+type Conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+// Send wraps payload in an envelope and writes it.
+func (c *Conn) Send(typ Type, id string, payload interface{}) error {
+	env, err := New(typ, id, payload)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(env)
+}
+
+// SendError writes an error envelope.
+func (c *Conn) SendError(id, code, message string) error {
+	return c.Send(TypeError, id, Error{Code: code, Message: message})
+}
+
+func (c *Conn) Receive() (Envelope, error) {
+	var env Envelope
+	err := c.ws.ReadJSON(&env)
+	return env, err
+}
+
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// Handshake performs the client side of the handshake. A refusal from the
+// gateway is returned as *Error.
+func Handshake(c *Conn, hello Hello) (Welcome, error) {
+	if hello.Version == 0 {
+		hello.Version = Version
+	}
+	if err := c.Send(TypeHello, "", hello); err != nil {
+		return Welcome{}, err
+	}
+	env, err := c.Receive()
+	if err != nil {
+		return Welcome{}, err
+	}
+	switch env.Type {
+	case TypeWelcome:
+		var w Welcome
+		err := env.Decode(&w)
+		return w, err
+	case TypeError:
+		e := &Error{}
+		if err := env.Decode(e); err != nil {
+			return Welcome{}, err
+		}
+		return Welcome{}, e
+	default:
+		return Welcome{}, fmt.Errorf("unexpected %s message during handshake", env.Type)
+	}
+}
@@ -0,0 +1,158 @@
+// Package protocol defines the messages exchanged over the gateway WebSocket.
+//
+// Every frame is a JSON Envelope. A connection opens with a handshake: the
+// client sends "hello" with the protocol version it speaks and optionally a
+// session to resume, and the gateway answers "welcome" with the version both
+// sides will use, or "error" if it cannot serve the client. Afterwards the
+// client sends "chat" requests; everything the gateway sends while answering
+// a request ("delta", "tool_call", "tool_result", "done", "error") carries the
+// request's ID. "ping" may be sent at any time and is answered with a "pong"
+// echoing its ID.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the newest protocol version this build speaks. MinVersion is
+// the oldest one it still accepts from a peer.
+const (
+	Version    = 1
+	MinVersion = 1
+)
+
+type Type string
+
+const (
+	TypeHello      Type = "hello"       // client → gateway: Hello
+	TypeWelcome    Type = "welcome"     // gateway → client: Welcome
+	TypeHistory    Type = "history"     // gateway → client: History, after welcome when resuming
+	TypeChat       Type = "chat"        // client → gateway: Chat
+	TypeDelta      Type = "delta"       // gateway → client: Delta
+	TypeToolCall   Type = "tool_call"   // gateway → client: ToolCall
+	TypeToolResult Type = "tool_result" // gateway → client: ToolResult
+	TypeDone       Type = "done"        // gateway → client: Done
+	TypeError      Type = "error"       // either direction: Error
+	TypePing       Type = "ping"        // either direction, no payload
+	TypePong       Type = "pong"        // reply to ping, no payload
+)
+
+// Envelope is a single WebSocket frame.
+type Envelope struct {
+	Type Type `json:"type"`
+	// ID correlates responses with the request that caused them.
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// New wraps payload, which may be nil, in an envelope.
+func New(typ Type, id string, payload interface{}) (Envelope, error) {
+	env := Envelope{Type: typ, ID: id}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return env, fmt.Errorf("encode %s payload: %w", typ, err)
+		}
+		env.Payload = raw
+	}
+	return env, nil
+}
+
+// Decode unmarshals the payload into v.
+func (e Envelope) Decode(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%s message has no payload", e.Type)
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("decode %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Hello opens the handshake.
+type Hello struct {
+	Version int    `json:"version"`
+	Client  string `json:"client"`            // e.g. "poe-tui/1"
+	Session string `json:"session,omitempty"` // session to resume; empty starts a new one
+	Token   string `json:"token,omitempty"`   // gateway token, required from other machines
+}
+
+// Welcome completes the handshake.
+type Welcome struct {
+	Version int    `json:"version"` // version both sides will speak
+	Session string `json:"session"`
+	Resumed bool   `json:"resumed"`
+}
+
+// History replays the working memory of a resumed session.
+type History struct {
+	Messages []HistoryMessage `json:"messages"`
+}
+
+type HistoryMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Chat is a user message to Poe.
+type Chat struct {
+	Content string `json:"content"`
+}
+
+// Delta is a fragment of Poe's reply.
+type Delta struct {
+	Content string `json:"content"`
+}
+
+// ToolCall reports that Poe started using a tool.
+type ToolCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// ToolResult reports that a tool finished.
+type ToolResult struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Done ends a reply.
+type Done struct {
+	Content string `json:"content"` // the full reply text
+}
+
+// Error codes.
+const (
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeSessionNotFound    = "session_not_found"
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized" // token missing or wrong
+	CodeBusy               = "busy"         // a reply is already in progress
+	CodeInternal           = "internal"
+)
+
+// Error reports a failure. It is also returned as a Go error by Handshake.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Negotiate picks the version to speak with a peer that speaks up to peer.
+func Negotiate(peer int) (int, error) {
+	if peer < MinVersion {
+		return 0, &Error{
+			Code:    CodeUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d is not supported (need %d..%d)", peer, MinVersion, Version),
+		}
+	}
+	if peer > Version {
+		return Version, nil
+	}
+	return peer, nil
+}
@@ -0,0 +1,38 @@
+package protocol_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/protocol"
+)
+
+func TestNegotiate(t *testing.T) {
+	if v, err := protocol.Negotiate(protocol.Version + 1); err != nil || v != protocol.Version {
+		t.Errorf("Negotiate(newer) = %d, %v; want %d", v, err, protocol.Version)
+	}
+	if v, err := protocol.Negotiate(protocol.MinVersion); err != nil || v != protocol.MinVersion {
+		t.Errorf("Negotiate(min) = %d, %v; want %d", v, err, protocol.MinVersion)
+	}
+	_, err := protocol.Negotiate(protocol.MinVersion - 1)
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeUnsupportedVersion {
+		t.Errorf("Negotiate(older) error = %v, want %s", err, protocol.CodeUnsupportedVersion)
+	}
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	env, err := protocol.New(protocol.TypeChat, "7", protocol.Chat{Content: "hi"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var chat protocol.Chat
+	if err := env.Decode(&chat); err != nil || chat.Content != "hi" || env.ID != "7" {
+		t.Errorf("Decode() = %+v, %v", chat, err)
+	}
+
+	ping, _ := protocol.New(protocol.TypePing, "", nil)
+	if err := ping.Decode(&chat); err == nil {
+		t.Error("Decode() of an empty payload succeeded")
+	}
+}
@@ -18,6 +18,10 @@ func New(homeDir string) *Manager {
 }
 
 func (m *Manager) Init() error {
+	if err := os.MkdirAll(filepath.Dir(m.agentsPath), 0755); err != nil {
+		return err
+	}
+
 	// Create default AGENTS.md if not exists
 	if _, err := os.Stat(m.agentsPath); os.IsNotExist(err) {
 		content := `# Poe Personality Prompt
@@ -50,3 +54,8 @@ func (m *Manager) GetPrompt() (string, error) {
 	}
 	return string(agents) + "\n" + string(soul), nil
 }
+
+// Paths returns the files GetPrompt reads.
+func (m *Manager) Paths() []string {
+	return []string{m.agentsPath, m.soulPath}
+}
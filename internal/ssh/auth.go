@@ -0,0 +1,147 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/crypt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentEnv names the environment variable locating the ssh-agent socket.
+const AgentEnv = "SSH_AUTH_SOCK"
+
+// auth returns how to authenticate to the node: with the keys of the
+// ssh-agent, then with the user certificate and the configured key. The
+// returned func closes the agent connection once the handshake is done.
+// c.mu is held.
+func (c *Client) auth() ([]ssh.AuthMethod, func(), error) {
+	var signers []ssh.Signer
+	closeAgent := func() {}
+
+	if c.node.Agent || c.node.Key == "" {
+		agentSigners, closer, err := agentSigners()
+		if err != nil && c.node.Key == "" {
+			return nil, nil, err
+		}
+		if err == nil {
+			signers = append(signers, agentSigners...)
+			closeAgent = closer
+		}
+	}
+
+	if c.node.Key != "" {
+		keySigners, err := c.keySigners()
+		if err != nil {
+			closeAgent()
+			return nil, nil, err
+		}
+		signers = append(signers, keySigners...)
+	}
+
+	// One method: the client does not try a second method of the same kind.
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, closeAgent, nil
+}
+
+// agentSigners returns the keys and certificates held by the ssh-agent.
+func agentSigners() ([]ssh.Signer, func(), error) {
+	sock := os.Getenv(AgentEnv)
+	if sock == "" {
+		return nil, nil, fmt.Errorf("ssh-agent: %s is not set", AgentEnv)
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	return signers, func() { conn.Close() }, nil
+}
+
+// keySigners returns the configured key, preceded by its certificate if it
+// has one. The key is parsed, and its passphrase asked for, once; the
+// certificate is read on every connection, as certificates are often
+// short-lived and renewed in place.
+func (c *Client) keySigners() ([]ssh.Signer, error) {
+	if c.signer == nil {
+		signer, err := c.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		c.signer = signer
+	}
+
+	certPath := c.node.Certificate
+	if certPath == "" {
+		certPath = c.node.Key + "-cert.pub"
+		if _, err := os.Stat(certPath); err != nil {
+			return []ssh.Signer{c.signer}, nil
+		}
+	}
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s: %w", certPath, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not a certificate", certPath)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, c.signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", certPath, err)
+	}
+	return []ssh.Signer{certSigner, c.signer}, nil
+}
+
+// parseKey reads the configured key, unlocking it with the passphrase from
+// the config or Options.Passphrase if it is encrypted.
+func (c *Client) parseKey() (ssh.Signer, error) {
+	data, err := os.ReadFile(c.node.Key)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		if passphrase, err = c.passphrase(); err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key %s: %w", c.node.Key, err)
+	}
+	return signer, nil
+}
+
+func (c *Client) passphrase() ([]byte, error) {
+	switch {
+	case crypt.IsSealed(c.node.Passphrase) && c.opts.Passphrase != nil:
+		// The configuration was not unlocked; ask for the key's passphrase
+		// rather than for Poe's.
+		return c.opts.Passphrase(c.node.Key)
+	case crypt.IsSealed(c.node.Passphrase):
+		return nil, fmt.Errorf("key %s: passphrase is encrypted and the configuration was not unlocked", c.node.Key)
+	case c.node.Passphrase != "":
+		p, err := config.ResolveSecret(c.node.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("key passphrase: %w", err)
+		}
+		return []byte(p), nil
+	case c.opts.Passphrase != nil:
+		return c.opts.Passphrase(c.node.Key)
+	}
+	return nil, fmt.Errorf("key %s is encrypted and no passphrase is configured", c.node.Key)
+}
@@ -3,16 +3,52 @@ package ssh
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/fjrt/poeai/internal/config"
 	"golang.org/x/crypto/ssh"
 )
 
+// Default Options.
+const (
+	DefaultKeepAlive = 30 * time.Second
+	DefaultTimeout   = 10 * time.Second
+)
+
+// Options configure how a Client connects.
+type Options struct {
+	// KnownHosts is the known_hosts file host keys are verified against;
+	// empty means ~/.ssh/known_hosts. Hosts not in it are trusted on first
+	// use and added to it.
+	KnownHosts string
+	// KeepAlive is the interval of keep-alive requests on an idle
+	// connection; a connection that does not answer is closed.
+	KeepAlive time.Duration
+	// Timeout bounds connecting and the SSH handshake.
+	Timeout time.Duration
+	// Passphrase is asked for the passphrase of an encrypted key that has
+	// none configured; nil fails instead, as a daemon cannot ask.
+	Passphrase func(keyPath string) ([]byte, error)
+}
+
+// Client runs commands on one host over a single SSH connection, which is
+// opened on first use, kept alive and reopened when it breaks. It is safe
+// for concurrent use.
 type Client struct {
-	host string
-	user string
-	key  string
+	addr string // host:port
+	node config.NodeConfig
+	opts Options
+
+	mu     sync.Mutex
+	signer ssh.Signer // node.Key, parsed on first use
+	conn   *ssh.Client
 }
 
 type Result struct {
@@ -21,61 +57,196 @@ type Result struct {
 	ExitCode int
 }
 
+// New returns a client for host, which may include a port (default 22),
+// with default Options.
 func New(host, user, keyPath string) *Client {
-	return &Client{host: host, user: user, key: keyPath}
+	return NewWith(host, user, keyPath, Options{})
+}
+
+// NewWith returns a client for host with opts.
+func NewWith(host, user, keyPath string, opts Options) *Client {
+	return NewNode(config.NodeConfig{Host: host, User: user, Key: keyPath}, opts)
 }
 
+// NewNode returns a client for a configured node.
+func NewNode(n config.NodeConfig, opts Options) *Client {
+	if opts.KnownHosts == "" {
+		home, _ := os.UserHomeDir()
+		opts.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Client{addr: Addr(n.Host), node: n, opts: opts}
+}
+
+// Addr returns host with the SSH port added if it has none.
+func Addr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "22")
+}
+
+// Exec runs cmd on the node and returns its output once it has exited.
+// Use Start to see output as it arrives.
 func (c *Client) Exec(ctx context.Context, cmd string) (Result, error) {
-	key, err := os.ReadFile(c.key)
+	p, err := c.Start(ctx, cmd, StartOptions{})
 	if err != nil {
-		return Result{}, fmt.Errorf("read key: %w", err)
+		return Result{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	for chunk := range p.Output {
+		if chunk.Stderr {
+			stderr.Write(chunk.Data)
+		} else {
+			stdout.Write(chunk.Data)
+		}
 	}
-	signer, err := ssh.ParsePrivateKey(key)
+	code, err := p.Wait()
 	if err != nil {
-		return Result{}, fmt.Errorf("parse key: %w", err)
+		return Result{}, err
 	}
-	cfg := &ssh.ClientConfig{
-		User:            c.user,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: support known_hosts
+	return Result{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: code}, nil
+}
+
+// session opens a session on the pooled connection, reconnecting once if
+// the connection turns out to be broken.
+func (c *Client) session(ctx context.Context) (*ssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sess, err := conn.NewSession()
+		if err == nil {
+			return sess, nil
+		}
+		c.drop(conn)
+		if attempt > 0 {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+	}
+}
+
+// connect returns the pooled connection, dialing it if there is none.
+func (c *Client) connect(ctx context.Context) (*ssh.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
 	}
 
-	// Dial with context
-	conn, err := ssh.Dial("tcp", c.host+":22", cfg)
+	auth, closeAgent, err := c.auth()
 	if err != nil {
-		return Result{}, fmt.Errorf("dial %s: %w", c.host, err)
+		return nil, err
+	}
+	defer closeAgent()
+	cfg := &ssh.ClientConfig{
+		User:            c.node.User,
+		Auth:            auth,
+		HostKeyCallback: knownHosts(c.opts.KnownHosts),
+		Timeout:         c.opts.Timeout,
+		// Ask for the key types already recorded for the host, if any.
+		HostKeyAlgorithms: hostKeyAlgorithms(c.opts.KnownHosts, c.addr),
 	}
-	defer conn.Close()
 
-	sess, err := conn.NewSession()
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	var d net.Dialer
+	tcp, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", c.addr, err)
+	}
+	// The handshake is bounded by a deadline, as ssh.NewClientConn does not
+	// take a context.
+	if deadline, ok := ctx.Deadline(); ok {
+		tcp.SetDeadline(deadline)
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(tcp, c.addr, cfg)
 	if err != nil {
-		return Result{}, fmt.Errorf("session: %w", err)
+		tcp.Close()
+		return nil, fmt.Errorf("dial %s: %w", c.addr, err)
 	}
-	defer sess.Close()
+	tcp.SetDeadline(time.Time{})
 
-	var stdout, stderr bytes.Buffer
-	sess.Stdout = &stdout
-	sess.Stderr = &stderr
+	c.conn = ssh.NewClient(sc, chans, reqs)
+	go c.keepAlive(c.conn)
+	return c.conn, nil
+}
 
-	// Handle context cancellation
-	done := make(chan error, 1)
+// keepAlive sends keep-alive requests on conn until it closes, and closes it
+// when one goes unanswered.
+func (c *Client) keepAlive(conn *ssh.Client) {
+	closed := make(chan struct{})
 	go func() {
-		done <- sess.Run(cmd)
+		conn.Wait()
+		close(closed)
 	}()
-
-	select {
-	case <-ctx.Done():
-		sess.Signal(ssh.SIGKILL)
-		return Result{}, ctx.Err()
-	case err := <-done:
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*ssh.ExitError); ok {
-				exitCode = exitErr.ExitStatus()
-			} else {
-				return Result{}, fmt.Errorf("run: %w", err)
+	ticker := time.NewTicker(c.opts.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			c.drop(conn)
+			return
+		case <-ticker.C:
+		}
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case err := <-reply:
+			if err == nil {
+				continue
 			}
+		case <-time.After(c.opts.KeepAlive):
 		}
-		return Result{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: exitCode}, nil
+		c.drop(conn)
+		return
+	}
+}
+
+// drop closes conn and, if it is still the pooled connection, forgets it.
+func (c *Client) drop(conn *ssh.Client) {
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	conn.Close()
+}
+
+// Close closes the connection, if one is open. The client can still be
+// used; it reconnects on the next command.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
 	}
+	return conn.Close()
+}
+
+// HostKeyError is returned when a host presents a key other than the one
+// recorded for it in known_hosts, which may mean the connection is being
+// intercepted.
+type HostKeyError struct {
+	Host string
+	File string
+	Line int
 }
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("host key for %s does not match %s:%d; if the host was reinstalled, remove that line", e.Host, e.File, e.Line)
+}
+
+// ErrRevoked is returned for host keys marked @revoked in known_hosts.
+var ErrRevoked = errors.New("host key is revoked")
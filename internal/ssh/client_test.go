@@ -2,9 +2,28 @@ package ssh_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/fjrt/poeai/internal/config"
 	"github.com/fjrt/poeai/internal/ssh"
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func TestClient_Exec_Error(t *testing.T) {
@@ -15,3 +34,431 @@ func TestClient_Exec_Error(t *testing.T) {
 		t.Error("Exec() with non-existent key should fail")
 	}
 }
+
+// testServer is an in-process SSH server that authenticates users with auth
+// and answers "exec" requests with "ran: <command>"; the command "fail"
+// exits with status 3.
+type testServer struct {
+	addr  string
+	conns atomic.Int32 // connections accepted
+	cfg   *xssh.ServerConfig
+}
+
+// newTestServer starts a testServer with hostKeys, or a new Ed25519 host key
+// if none are given.
+func newTestServer(t *testing.T, auth func(xssh.ConnMetadata, xssh.PublicKey) (*xssh.Permissions, error), hostKeys ...xssh.Signer) *testServer {
+	t.Helper()
+	s := &testServer{cfg: &xssh.ServerConfig{PublicKeyCallback: auth}}
+	for _, k := range hostKeys {
+		s.cfg.AddHostKey(k)
+	}
+	if len(hostKeys) == 0 {
+		s.setHostKey(t)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+// acceptKey authorizes one user key, like authorized_keys.
+func acceptKey(userKey xssh.PublicKey) func(xssh.ConnMetadata, xssh.PublicKey) (*xssh.Permissions, error) {
+	return func(meta xssh.ConnMetadata, key xssh.PublicKey) (*xssh.Permissions, error) {
+		if string(key.Marshal()) == string(userKey.Marshal()) {
+			return nil, nil
+		}
+		return nil, errors.New("unknown key")
+	}
+}
+
+// setHostKey gives the server a new host key.
+func (s *testServer) setHostKey(t *testing.T) {
+	t.Helper()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := xssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+	s.cfg.AddHostKey(signer)
+}
+
+func (s *testServer) serve(c net.Conn) {
+	_, chans, reqs, err := xssh.NewServerConn(c, s.cfg)
+	if err != nil {
+		c.Close()
+		return
+	}
+	go xssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(xssh.UnknownChannelType, "no")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(ch, chReqs)
+	}
+}
+
+// session serves one session channel. Besides the commands described on
+// testServer, "stream" writes "first", waits for stdin to close and writes
+// "last". A shell reports its terminal size and every resize, and exits when
+// stdin closes.
+func (s *testServer) session(ch xssh.Channel, reqs <-chan *xssh.Request) {
+	var pty string
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			var r struct {
+				Term             string
+				Cols, Rows, W, H uint32
+				Modes            string
+			}
+			xssh.Unmarshal(req.Payload, &r)
+			pty = fmt.Sprintf("%dx%d", r.Cols, r.Rows)
+			req.Reply(true, nil)
+		case "window-change":
+			var r struct{ Cols, Rows, W, H uint32 }
+			xssh.Unmarshal(req.Payload, &r)
+			fmt.Fprintf(ch, "resize %dx%d\n", r.Cols, r.Rows)
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(ch, "pty %s\n", pty)
+			go func() {
+				io.Copy(io.Discard, ch)
+				exit(ch, 0)
+			}()
+		case "exec":
+			var r struct{ Command string }
+			xssh.Unmarshal(req.Payload, &r)
+			req.Reply(true, nil)
+			go func(cmd string) {
+				switch cmd {
+				case "fail":
+					ch.Stderr().Write([]byte("failed\n"))
+					exit(ch, 3)
+					return
+				case "stream":
+					ch.Write([]byte("first\n"))
+					io.Copy(io.Discard, ch)
+					ch.Write([]byte("last\n"))
+				default:
+					ch.Write([]byte("ran: " + cmd + "\n"))
+				}
+				exit(ch, 0)
+			}(r.Command)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func exit(ch xssh.Channel, status uint32) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, status)
+	ch.SendRequest("exit-status", false, payload)
+	ch.Close()
+}
+
+// newUserKey writes a new private key to dir, encrypted if passphrase is
+// not empty, and returns its path and public key.
+func newUserKey(t *testing.T, dir, passphrase string) (string, xssh.PublicKey) {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := xssh.MarshalPrivateKey(priv, "")
+	if passphrase != "" {
+		block, err = xssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() error = %v", err)
+	}
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, _ := xssh.NewPublicKey(pub)
+	return path, sshPub
+}
+
+func TestClient_PoolsAndVerifiesHostKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, pub := newUserKey(t, dir, "")
+	srv := newTestServer(t, acceptKey(pub))
+	knownHosts := filepath.Join(dir, "ssh", "known_hosts")
+	ctx := context.Background()
+
+	client := ssh.NewWith(srv.addr, "fjrt", keyPath, ssh.Options{KnownHosts: knownHosts})
+	defer client.Close()
+	res, err := client.Exec(ctx, "uptime")
+	if err != nil || res.Stdout != "ran: uptime\n" || res.ExitCode != 0 {
+		t.Fatalf("Exec() = %+v, %v", res, err)
+	}
+	res, err = client.Exec(ctx, "fail")
+	if err != nil || res.ExitCode != 3 || res.Stderr != "failed\n" {
+		t.Errorf("Exec(fail) = %+v, %v; want exit status 3", res, err)
+	}
+	if n := srv.conns.Load(); n != 1 {
+		t.Errorf("two commands opened %d connections, want 1", n)
+	}
+
+	// The host was trusted on first use, under its [host]:port name.
+	data, err := os.ReadFile(knownHosts)
+	if err != nil || !strings.HasPrefix(string(data), "[127.0.0.1]:") {
+		t.Fatalf("known_hosts = %q, %v", data, err)
+	}
+
+	// After a reinstall, the same address presents another key.
+	srv.setHostKey(t)
+	client.Close()
+	_, err = client.Exec(ctx, "uptime")
+	var hkErr *ssh.HostKeyError
+	if !errors.As(err, &hkErr) || hkErr.Line != 1 {
+		t.Errorf("Exec() with changed host key: err = %v, want *HostKeyError", err)
+	}
+}
+
+func TestClient_PrefersKnownHostKeyType(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, pub := newUserKey(t, dir, "")
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	var signers []xssh.Signer
+	for _, k := range []any{edPriv, ecPriv} {
+		signer, err := xssh.NewSignerFromKey(k)
+		if err != nil {
+			t.Fatalf("NewSignerFromKey() error = %v", err)
+		}
+		signers = append(signers, signer)
+	}
+	// The server offers its ECDSA key before the recorded Ed25519 one unless
+	// the client asks for the latter.
+	srv := newTestServer(t, acceptKey(pub), signers...)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, signers[0].PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := ssh.NewWith(srv.addr, "fjrt", keyPath, ssh.Options{KnownHosts: knownHosts})
+	defer client.Close()
+	if _, err := client.Exec(context.Background(), "uptime"); err != nil {
+		t.Errorf("Exec() with another key type offered first: %v", err)
+	}
+}
+
+func TestPool_ReplacesChangedNode(t *testing.T) {
+	pool := ssh.NewPool(ssh.Options{KnownHosts: filepath.Join(t.TempDir(), "known_hosts")})
+	defer pool.Close()
+	pi := config.NodeConfig{Host: "pi.lan", User: "fjrt"}
+	a := pool.Client("pi", pi)
+	if pool.Client("pi", pi) != a {
+		t.Error("same node got a new client")
+	}
+	pi.Host = "pi.lan:2222"
+	if pool.Client("pi", pi) == a {
+		t.Error("changed node kept its client")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	for host, want := range map[string]string{
+		"pi.lan":        "pi.lan:22",
+		"pi.lan:2222":   "pi.lan:2222",
+		"10.0.0.5":      "10.0.0.5:22",
+		"fd00::5":       "[fd00::5]:22",
+		"[fd00::5]":     "[fd00::5]:22",
+		"[fd00::5]:222": "[fd00::5]:222",
+	} {
+		if got := ssh.Addr(host); got != want {
+			t.Errorf("Addr(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+// run connects to srv as node and runs one command.
+func run(t *testing.T, srv *testServer, n config.NodeConfig, opts ssh.Options) error {
+	t.Helper()
+	n.Host, n.User = srv.addr, "fjrt"
+	opts.KnownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	client := ssh.NewNode(n, opts)
+	defer client.Close()
+	res, err := client.Exec(context.Background(), "id")
+	if err == nil && res.Stdout != "ran: id\n" {
+		t.Errorf("Exec() stdout = %q", res.Stdout)
+	}
+	return err
+}
+
+func TestClient_EncryptedKey(t *testing.T) {
+	keyPath, pub := newUserKey(t, t.TempDir(), "hunter22")
+	srv := newTestServer(t, acceptKey(pub))
+
+	if err := run(t, srv, config.NodeConfig{Key: keyPath}, ssh.Options{}); err == nil {
+		t.Error("encrypted key without a passphrase was used")
+	}
+	t.Setenv("POE_TEST_KEY_PASSPHRASE", "hunter22")
+	if err := run(t, srv, config.NodeConfig{Key: keyPath, Passphrase: "env:POE_TEST_KEY_PASSPHRASE"}, ssh.Options{}); err != nil {
+		t.Errorf("passphrase from a secret reference: %v", err)
+	}
+	asked := ""
+	prompt := func(path string) ([]byte, error) {
+		asked = path
+		return []byte("hunter22"), nil
+	}
+	if err := run(t, srv, config.NodeConfig{Key: keyPath}, ssh.Options{Passphrase: prompt}); err != nil || asked != keyPath {
+		t.Errorf("prompted passphrase: err = %v, asked for %q", err, asked)
+	}
+}
+
+func TestClient_Certificate(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, pub := newUserKey(t, dir, "")
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, _ := xssh.NewSignerFromKey(caKey)
+	// The node trusts the CA only, not the user's key itself.
+	checker := &xssh.CertChecker{
+		IsUserAuthority: func(auth xssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	srv := newTestServer(t, checker.Authenticate)
+
+	if err := run(t, srv, config.NodeConfig{Key: keyPath}, ssh.Options{}); err == nil {
+		t.Fatal("key without certificate was accepted")
+	}
+
+	cert := &xssh.Certificate{
+		Key:             pub,
+		CertType:        xssh.UserCert,
+		KeyId:           "fjrt",
+		ValidPrincipals: []string{"fjrt"},
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert() error = %v", err)
+	}
+	os.WriteFile(keyPath+"-cert.pub", xssh.MarshalAuthorizedKey(cert), 0644)
+	if err := run(t, srv, config.NodeConfig{Key: keyPath}, ssh.Options{}); err != nil {
+		t.Errorf("key with certificate: %v", err)
+	}
+}
+
+func TestClient_Agent(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				agent.ServeAgent(keyring, c)
+			}()
+		}
+	}()
+	signers, _ := keyring.Signers()
+	srv := newTestServer(t, acceptKey(signers[0].PublicKey()))
+
+	t.Setenv(ssh.AgentEnv, "")
+	if err := run(t, srv, config.NodeConfig{}, ssh.Options{}); err == nil {
+		t.Error("node without key or agent connected")
+	}
+	t.Setenv(ssh.AgentEnv, sock)
+	if err := run(t, srv, config.NodeConfig{}, ssh.Options{}); err != nil {
+		t.Errorf("agent auth: %v", err)
+	}
+}
+
+func TestClient_Start(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, pub := newUserKey(t, dir, "")
+	srv := newTestServer(t, acceptKey(pub))
+	client := ssh.NewWith(srv.addr, "fjrt", keyPath, ssh.Options{KnownHosts: filepath.Join(dir, "known_hosts")})
+	defer client.Close()
+	ctx := context.Background()
+
+	// Output arrives while the command is still running, waiting for stdin.
+	stdin, w := io.Pipe()
+	p, err := client.Start(ctx, "stream", ssh.StartOptions{Stdin: stdin})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if c := <-p.Output; string(c.Data) != "first\n" || c.Stderr {
+		t.Errorf("first chunk = %+v", c)
+	}
+	w.Close()
+	var rest strings.Builder
+	for c := range p.Output {
+		rest.Write(c.Data)
+	}
+	if code, err := p.Wait(); err != nil || code != 0 || rest.String() != "last\n" {
+		t.Errorf("after closing stdin: output %q, Wait() = %d, %v", rest.String(), code, err)
+	}
+
+	// A shell on a terminal is told its size, and about resizes.
+	stdin, w = io.Pipe()
+	p, err = client.Start(ctx, "", ssh.StartOptions{Stdin: stdin, PTY: &ssh.PTY{Width: 80, Height: 24}})
+	if err != nil {
+		t.Fatalf("Start(shell) error = %v", err)
+	}
+	if c := <-p.Output; string(c.Data) != "pty 80x24\n" {
+		t.Errorf("shell got %q, want pty 80x24", c.Data)
+	}
+	if err := p.Resize(120, 40); err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	if c := <-p.Output; string(c.Data) != "resize 120x40\n" {
+		t.Errorf("after Resize got %q", c.Data)
+	}
+	w.Close()
+	for range p.Output {
+	}
+	if _, err := p.Wait(); err != nil {
+		t.Errorf("shell Wait() error = %v", err)
+	}
+
+	// Canceling the context kills a command that would not end by itself.
+	ctx, cancel := context.WithCancel(ctx)
+	stdin, w = io.Pipe()
+	defer w.Close()
+	p, err = client.Start(ctx, "stream", ssh.StartOptions{Stdin: stdin})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	<-p.Output
+	cancel()
+	for range p.Output {
+	}
+	if _, err := p.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() after cancel error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,121 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu serializes trust-on-first-use additions to known_hosts files.
+var knownHostsMu sync.Mutex
+
+// knownHosts returns a callback that verifies host keys against the
+// known_hosts file at path. The key of a host that is not in the file is
+// trusted and appended to it, creating the file if needed; a host whose key
+// differs from the recorded one is refused with a *HostKeyError.
+func knownHosts(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		if _, err := os.Stat(path); err == nil {
+			check, err := knownhosts.New(path)
+			if err != nil {
+				return fmt.Errorf("known_hosts: %w", err)
+			}
+			err = check(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			var revoked *knownhosts.RevokedError
+			switch {
+			case err == nil:
+				return nil
+			case errors.As(err, &revoked):
+				return fmt.Errorf("%s: %w", hostname, ErrRevoked)
+			case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+				return &HostKeyError{Host: hostname, File: keyErr.Want[0].Filename, Line: keyErr.Want[0].Line}
+			case !errors.As(err, &keyErr):
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("known_hosts: %w", err)
+		}
+		return trust(path, hostname, key)
+	}
+}
+
+// hostKeyAlgorithms returns the host key algorithms of the keys recorded for
+// hostname in the known_hosts file at path, as OpenSSH asks for them, so
+// that a server offering another key type first is not taken for a changed
+// host. It returns nil, leaving the choice to the server, for hosts that are
+// not recorded yet.
+func hostKeyAlgorithms(path, hostname string) []string {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil
+	}
+	// Checking a key that no host has lists the keys recorded for hostname.
+	// The remote address is only used when there is no hostname.
+	err = check(hostname, &net.TCPAddr{IP: net.IPv6loopback}, probeKey)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algos []string
+	for _, k := range keyErr.Want {
+		for _, a := range keyAlgorithms(k.Key.Type()) {
+			if !slices.Contains(algos, a) {
+				algos = append(algos, a)
+			}
+		}
+	}
+	return algos
+}
+
+// keyAlgorithms returns the signature algorithms of a host key type: RSA
+// keys sign with SHA-2 or, on old servers, SHA-1.
+func keyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	}
+	return []string{keyType}
+}
+
+// probeKey is a key of no host, for hostKeyAlgorithms.
+var probeKey = func() ssh.PublicKey {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	key, _ := ssh.NewPublicKey(pub)
+	return key
+}()
+
+// trust appends key for hostname to the known_hosts file at path.
+func trust(path, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("known_hosts: %w", err)
+	}
+	return nil
+}
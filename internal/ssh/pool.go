@@ -0,0 +1,61 @@
+package ssh
+
+import (
+	"sync"
+
+	"github.com/fjrt/poeai/internal/config"
+)
+
+// Pool holds one Client, and so one connection, per configured node.
+// It is safe for concurrent use.
+type Pool struct {
+	opts Options
+
+	mu      sync.Mutex
+	clients map[string]pooled
+}
+
+type pooled struct {
+	node   config.NodeConfig
+	client *Client
+}
+
+// NewPool returns an empty pool whose clients connect with opts.
+func NewPool(opts Options) *Pool {
+	return &Pool{opts: opts, clients: make(map[string]pooled)}
+}
+
+// Client returns the client for the node called name. If the node's
+// configuration changed since the last call, the old connection is closed
+// and a new client is made.
+func (p *Pool) Client(name string, n config.NodeConfig) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[name]; ok {
+		if sameConnection(c.node, n) {
+			return c.client
+		}
+		c.client.Close()
+	}
+	c := NewNode(n, p.opts)
+	p.clients[name] = pooled{node: n, client: c}
+	return c
+}
+
+// Close closes all connections.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, c := range p.clients {
+		c.client.Close()
+		delete(p.clients, name)
+	}
+	return nil
+}
+
+// sameConnection reports whether a and b connect the same way; other
+// settings, like command policies, do not need a new connection.
+func sameConnection(a, b config.NodeConfig) bool {
+	return a.Host == b.Host && a.User == b.User && a.Key == b.Key &&
+		a.Agent == b.Agent && a.Passphrase == b.Passphrase && a.Certificate == b.Certificate
+}
@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Chunk is a piece of a remote command's output, delivered as it arrives.
+type Chunk struct {
+	Data   []byte
+	Stderr bool // from stderr rather than stdout
+}
+
+// PTY requests a pseudo-terminal, which shells and other interactive
+// programs need. Stdout and stderr are merged by the terminal.
+type PTY struct {
+	Term          string // $TERM on the node; default "xterm-256color"
+	Width, Height int    // in characters
+}
+
+// StartOptions configure a command started with Start.
+type StartOptions struct {
+	Stdin io.Reader // piped to the command; nil for none
+	PTY   *PTY      // nil runs the command without a terminal
+}
+
+// Process is a command running on a node.
+type Process struct {
+	// Output delivers the command's output as it arrives. It is closed once
+	// the command has exited and all output was delivered, and must be
+	// drained until then.
+	Output <-chan Chunk
+
+	sess *ssh.Session
+	ctx  context.Context
+	done chan struct{}
+	code int
+	err  error
+}
+
+// Start runs cmd on the node and returns as soon as it started; an empty
+// cmd starts the login shell. Canceling ctx kills the command.
+func (c *Client) Start(ctx context.Context, cmd string, opts StartOptions) (*Process, error) {
+	sess, err := c.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Chunk, 64)
+	p := &Process{Output: out, sess: sess, ctx: ctx, done: make(chan struct{})}
+	sess.Stdin = opts.Stdin
+	sess.Stdout = chunkWriter{out, false, ctx.Done()}
+	sess.Stderr = chunkWriter{out, true, ctx.Done()}
+
+	if opts.PTY != nil {
+		term := opts.PTY.Term
+		if term == "" {
+			term = "xterm-256color"
+		}
+		modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err := sess.RequestPty(term, opts.PTY.Height, opts.PTY.Width, modes); err != nil {
+			sess.Close()
+			return nil, fmt.Errorf("pty: %w", err)
+		}
+	}
+	if cmd == "" {
+		err = sess.Shell()
+	} else {
+		err = sess.Start(cmd)
+	}
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("start: %w", err)
+	}
+
+	go func() {
+		err := sess.Wait()
+		close(out)
+		p.finish(err)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+		case <-p.done:
+		}
+	}()
+	return p, nil
+}
+
+func (p *Process) finish(err error) {
+	if exitErr, ok := err.(*ssh.ExitError); ok && p.ctx.Err() == nil {
+		p.code, err = exitErr.ExitStatus(), nil
+	}
+	switch {
+	case err != nil && p.ctx.Err() != nil:
+		err = p.ctx.Err()
+	case err != nil:
+		err = fmt.Errorf("run: %w", err)
+	}
+	p.err = err
+	p.sess.Close()
+	close(p.done)
+}
+
+// Wait waits for the command to exit and returns its exit code. The error
+// is the context's if it was canceled, and otherwise reports a failed
+// connection, not a failed command.
+func (p *Process) Wait() (int, error) {
+	<-p.done
+	return p.code, p.err
+}
+
+// Resize tells the command that its terminal changed size. It is only
+// meaningful for commands started with a PTY.
+func (p *Process) Resize(width, height int) error {
+	return p.sess.WindowChange(height, width)
+}
+
+// chunkWriter delivers writes as chunks until stop is closed.
+type chunkWriter struct {
+	out    chan<- Chunk
+	stderr bool
+	stop   <-chan struct{}
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	select {
+	case w.out <- Chunk{Data: bytes.Clone(p), Stderr: w.stderr}:
+		return len(p), nil
+	case <-w.stop:
+		return 0, io.ErrClosedPipe
+	}
+}
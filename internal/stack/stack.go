@@ -0,0 +1,310 @@
+// Package stack backs up and restores Poe's state: the memory database, the
+// SOUL.md and AGENTS.md prompts, and config.toml, bundled as one gzipped tar
+// archive with a manifest of checksums.
+package stack
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fjrt/poeai/internal/memory"
+)
+
+// Format is the archive layout version written by Backup.
+const Format = 1
+
+const manifestName = "manifest.json"
+
+// Archive entry names.
+const (
+	DBName     = "poe.db"
+	SoulName   = "SOUL.md"
+	AgentsName = "AGENTS.md"
+	ConfigName = "config.toml"
+)
+
+// Paths locates the files of a Poe installation.
+type Paths struct {
+	DB     string
+	Soul   string
+	Agents string
+	Config string
+}
+
+// DefaultPaths returns the paths under ~/.poe for the given home directory
+// and database path.
+func DefaultPaths(home, dbPath string) Paths {
+	dir := filepath.Join(home, ".poe")
+	return Paths{
+		DB:     dbPath,
+		Soul:   filepath.Join(dir, SoulName),
+		Agents: filepath.Join(dir, AgentsName),
+		Config: filepath.Join(dir, ConfigName),
+	}
+}
+
+// entries maps archive entry names to their paths on disk.
+func (p Paths) entries() map[string]string {
+	return map[string]string{
+		DBName:     p.DB,
+		SoulName:   p.Soul,
+		AgentsName: p.Agents,
+		ConfigName: p.Config,
+	}
+}
+
+// Manifest describes an archive. It is its first entry.
+type Manifest struct {
+	Format        int       `json:"format"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"` // of the database snapshot
+	Files         []File    `json:"files"`
+}
+
+// File is a manifest entry.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ErrChecksum is returned by Restore when an archived file does not match
+// the manifest.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Backup writes an archive of the installation at p to w. The database is
+// copied with SQLite's online backup API, so the gateway may keep running.
+// The database must exist; the other files are skipped if missing.
+func Backup(ctx context.Context, p Paths, w io.Writer) (Manifest, error) {
+	tmp, err := os.MkdirTemp("", "poe-backup-")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, DBName)
+	if err := memory.Backup(ctx, p.DB, snapshot); err != nil {
+		return Manifest{}, err
+	}
+	version, err := memory.FileSchemaVersion(ctx, snapshot)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read schema version: %w", err)
+	}
+
+	m := Manifest{Format: Format, CreatedAt: time.Now().UTC(), SchemaVersion: version}
+	paths := p.entries()
+	paths[DBName] = snapshot
+	for _, name := range []string{DBName, SoulName, AgentsName, ConfigName} {
+		f, err := checksum(paths[name])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+		f.Name = name
+		m.Files = append(m.Files, f)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := writeEntry(tw, manifestName, int64(len(manifest)), m.CreatedAt, bytes.NewReader(manifest)); err != nil {
+		return Manifest{}, err
+	}
+	for _, f := range m.Files {
+		src, err := os.Open(paths[f.Name])
+		if err != nil {
+			return Manifest{}, err
+		}
+		err = writeEntry(tw, f.Name, f.Size, m.CreatedAt, src)
+		src.Close()
+		if err != nil {
+			return Manifest{}, fmt.Errorf("archive %s: %w", f.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return Manifest{}, err
+	}
+	return m, gz.Close()
+}
+
+func checksum(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, mod time.Time, r io.Reader) error {
+	// Archives hold the config with its credentials; keep them private.
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: size, ModTime: mod, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// Restore unpacks an archive read from r over the installation at p. Every
+// file is checked against the manifest, and the database against the schema
+// versions this build supports, before anything is replaced. Files being
+// replaced are kept with a .bak suffix. The gateway must not be running.
+func Restore(ctx context.Context, r io.Reader, p Paths) (Manifest, error) {
+	var m Manifest
+	if err := os.MkdirAll(filepath.Dir(p.DB), 0700); err != nil {
+		return m, err
+	}
+	// Unpack next to the database so installing it is a rename.
+	tmp, err := os.MkdirTemp(filepath.Dir(p.DB), ".poe-restore-")
+	if err != nil {
+		return m, err
+	}
+	defer os.RemoveAll(tmp)
+
+	got, err := unpack(r, tmp)
+	if err != nil {
+		return m, err
+	}
+	buf, err := os.ReadFile(filepath.Join(tmp, manifestName))
+	if err != nil {
+		return m, fmt.Errorf("archive has no manifest")
+	}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return m, fmt.Errorf("manifest: %w", err)
+	}
+	if m.Format > Format {
+		return m, fmt.Errorf("archive format %d is newer than this build of Poe supports (%d)", m.Format, Format)
+	}
+
+	for _, f := range m.Files {
+		if !got[f.Name] {
+			return m, fmt.Errorf("%s: listed in the manifest but missing from the archive", f.Name)
+		}
+		delete(got, f.Name)
+		sum, err := checksum(filepath.Join(tmp, f.Name))
+		if err != nil {
+			return m, err
+		}
+		if sum.Size != f.Size || sum.SHA256 != f.SHA256 {
+			return m, fmt.Errorf("%s: %w", f.Name, ErrChecksum)
+		}
+	}
+	delete(got, manifestName)
+	for name := range got {
+		return m, fmt.Errorf("%s: not listed in the manifest", name)
+	}
+
+	version, err := memory.FileSchemaVersion(ctx, filepath.Join(tmp, DBName))
+	if err != nil {
+		return m, fmt.Errorf("archive database: %w", err)
+	}
+	if version > memory.LatestSchemaVersion() {
+		return m, fmt.Errorf("%w: archive is at version %d, this build supports up to %d",
+			memory.ErrSchemaTooNew, version, memory.LatestSchemaVersion())
+	}
+
+	dest := p.entries()
+	for _, f := range m.Files {
+		if err := install(filepath.Join(tmp, f.Name), dest[f.Name]); err != nil {
+			return m, fmt.Errorf("restore %s: %w", f.Name, err)
+		}
+	}
+	return m, nil
+}
+
+// unpack extracts the known entries of a gzipped tar into dir and reports
+// which were present.
+func unpack(r io.Reader, dir string) (map[string]bool, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read archive: %w", err)
+	}
+	defer gz.Close()
+	known := map[string]bool{manifestName: true, DBName: true, SoulName: true, AgentsName: true, ConfigName: true}
+	got := make(map[string]bool)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if !known[hdr.Name] || hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		if got[hdr.Name] {
+			return nil, fmt.Errorf("duplicate archive entry %q", hdr.Name)
+		}
+		got[hdr.Name] = true
+		out, err := os.OpenFile(filepath.Join(dir, hdr.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// install moves src to dest, keeping an existing dest as dest.bak. src is
+// renamed when possible and copied otherwise, e.g. across file systems.
+func install(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		if err := os.Rename(dest, dest+".bak"); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
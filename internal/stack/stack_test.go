@@ -0,0 +1,125 @@
+package stack_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fjrt/poeai/internal/memory"
+	"github.com/fjrt/poeai/internal/stack"
+)
+
+func install(t *testing.T) stack.Paths {
+	t.Helper()
+	dir := t.TempDir()
+	return stack.Paths{
+		DB:     filepath.Join(dir, "poe.db"),
+		Soul:   filepath.Join(dir, "SOUL.md"),
+		Agents: filepath.Join(dir, "AGENTS.md"),
+		Config: filepath.Join(dir, "config.toml"),
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src := install(t)
+	store, err := memory.Open(src.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := store.Write(ctx, memory.Memory{Type: memory.TypeSemantic, Source: "test", Content: "ha-server runs Debian 12"})
+	os.WriteFile(src.Soul, []byte("# soul\n"), 0644)
+	os.WriteFile(src.Config, []byte("[llm]\nprovider = \"ollama\"\n"), 0600)
+
+	// The store stays open, as in a running gateway.
+	var archive bytes.Buffer
+	m, err := stack.Backup(ctx, src, &archive)
+	store.Close()
+	if err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if len(m.Files) != 3 || m.SchemaVersion != memory.LatestSchemaVersion() {
+		t.Errorf("manifest = %+v, want 3 files (no AGENTS.md) at the latest schema", m)
+	}
+
+	dst := install(t)
+	os.WriteFile(dst.Config, []byte("old"), 0600)
+	if _, err := stack.Restore(ctx, bytes.NewReader(archive.Bytes()), dst); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, err := memory.Open(dst.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if got, ok, _ := restored.Get(ctx, id); !ok || got.Content != "ha-server runs Debian 12" {
+		t.Errorf("restored memory = %+v, %v", got, ok)
+	}
+	if buf, _ := os.ReadFile(dst.Soul); string(buf) != "# soul\n" {
+		t.Errorf("restored SOUL.md = %q", buf)
+	}
+	if buf, _ := os.ReadFile(dst.Config + ".bak"); string(buf) != "old" {
+		t.Errorf("config.toml.bak = %q, want the replaced config", buf)
+	}
+}
+
+func TestRestore_RejectsTamperedArchive(t *testing.T) {
+	ctx := context.Background()
+	src := install(t)
+	store, _ := memory.Open(src.DB)
+	store.Close()
+	os.WriteFile(src.Soul, []byte("# soul\n"), 0644)
+	var archive bytes.Buffer
+	if _, err := stack.Backup(ctx, src, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite SOUL.md inside the archive, keeping the manifest.
+	var tampered bytes.Buffer
+	gz, _ := gzip.NewReader(&archive)
+	tr := tar.NewReader(gz)
+	gw := gzip.NewWriter(&tampered)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		body, _ := io.ReadAll(tr)
+		if hdr.Name == "SOUL.md" {
+			body = []byte("# evil\n")
+			hdr.Size = int64(len(body))
+		}
+		if hdr.Name == "manifest.json" {
+			var m stack.Manifest
+			json.Unmarshal(body, &m)
+			for i := range m.Files {
+				if m.Files[i].Name == "SOUL.md" {
+					m.Files[i].Size = 7
+				}
+			}
+			body, _ = json.Marshal(m)
+			hdr.Size = int64(len(body))
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(body)
+	}
+	tw.Close()
+	gw.Close()
+
+	dst := install(t)
+	_, err := stack.Restore(ctx, &tampered, dst)
+	if !errors.Is(err, stack.ErrChecksum) {
+		t.Fatalf("Restore() error = %v, want ErrChecksum", err)
+	}
+	if _, err := os.Stat(dst.Soul); !os.IsNotExist(err) {
+		t.Error("Restore() installed files from a tampered archive")
+	}
+}
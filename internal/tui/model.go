@@ -2,28 +2,35 @@ package tui
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/gorilla/websocket"
+	"github.com/fjrt/poeai/internal/config"
+	"github.com/fjrt/poeai/internal/onboarding"
+	"github.com/fjrt/poeai/internal/protocol"
+	"github.com/fjrt/poeai/internal/ssh"
 )
 
 type model struct {
-	conn      *websocket.Conn
+	conn      *protocol.Conn
+	nodes     map[string]config.NodeConfig
+	ssh       *ssh.Pool
 	viewport  viewport.Model
 	textinput textinput.Model
 	messages  []string
+	sessionID string
+	streaming bool // last entry in messages is a reply still being streamed
+	nextID    int
 	err       error
 }
 
-type msgReceived struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-func NewModel(conn *websocket.Conn) model {
+// NewModel returns a model for a connection whose handshake has completed.
+// Shells can be opened on nodes with "/shell <node>".
+func NewModel(conn *protocol.Conn, welcome protocol.Welcome, nodes map[string]config.NodeConfig) model {
 	ti := textinput.New()
 	ti.Placeholder = "Say something to Poe..."
 	ti.Focus()
@@ -35,9 +42,12 @@ func NewModel(conn *websocket.Conn) model {
 
 	return model{
 		conn:      conn,
+		nodes:     nodes,
+		ssh:       ssh.NewPool(ssh.Options{Passphrase: onboarding.KeyPassphrase}),
 		viewport:  vp,
 		textinput: ti,
 		messages:  []string{},
+		sessionID: welcome.Session,
 	}
 }
 
@@ -47,11 +57,11 @@ func (m model) Init() tea.Cmd {
 
 func (m model) waitForMessage() tea.Cmd {
 	return func() tea.Msg {
-		var msg msgReceived
-		if err := m.conn.ReadJSON(&msg); err != nil {
+		env, err := m.conn.Receive()
+		if err != nil {
 			return err
 		}
-		return msg
+		return env
 	}
 }
 
@@ -74,22 +84,80 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if content == "" {
 				break
 			}
+			if word, name, _ := strings.Cut(content, " "); word == "/shell" {
+				m.textinput.Reset()
+				cmd := m.shell(strings.TrimSpace(name))
+				return m, cmd
+			}
 			m.messages = append(m.messages, styleUserMsg.Render("You: ")+content)
 			m.viewport.SetContent(strings.Join(m.messages, "\n"))
 			m.viewport.GotoBottom()
 
 			// Send to gateway
-			m.conn.WriteJSON(msgReceived{Role: "user", Content: content})
+			m.nextID++
+			if err := m.conn.Send(protocol.TypeChat, strconv.Itoa(m.nextID), protocol.Chat{Content: content}); err != nil {
+				m.err = err
+				return m, tea.Quit
+			}
 
 			m.textinput.Reset()
 		}
 
-	case msgReceived:
-		m.messages = append(m.messages, stylePoeMsg.Render("Poe: ")+msg.Content)
+	case protocol.Envelope:
+		switch msg.Type {
+		case protocol.TypeHistory:
+			var h protocol.History
+			if msg.Decode(&h) == nil {
+				for _, hm := range h.Messages {
+					if hm.Role == "user" {
+						m.messages = append(m.messages, styleUserMsg.Render("You: ")+hm.Content)
+					} else {
+						m.messages = append(m.messages, stylePoeMsg.Render("Poe: ")+hm.Content)
+					}
+				}
+			}
+		case protocol.TypeDelta:
+			var d protocol.Delta
+			if msg.Decode(&d) != nil {
+				break
+			}
+			if m.streaming {
+				m.messages[len(m.messages)-1] += d.Content
+			} else {
+				m.messages = append(m.messages, stylePoeMsg.Render("Poe: ")+d.Content)
+				m.streaming = true
+			}
+		case protocol.TypeToolCall:
+			var c protocol.ToolCall
+			if msg.Decode(&c) != nil {
+				break
+			}
+			m.streaming = false
+			m.messages = append(m.messages, styleToolMsg.Render("  ⚙ "+c.Name))
+		case protocol.TypeDone:
+			m.streaming = false
+		case protocol.TypeError:
+			var e protocol.Error
+			msg.Decode(&e)
+			m.streaming = false
+			m.messages = append(m.messages, styleErrorMsg.Render("Error: ")+e.Message)
+		default:
+			return m, m.waitForMessage()
+		}
 		m.viewport.SetContent(strings.Join(m.messages, "\n"))
 		m.viewport.GotoBottom()
 		return m, m.waitForMessage()
 
+	case shellExited:
+		if msg.err != nil {
+			m.messages = append(m.messages, styleErrorMsg.Render("Shell on "+msg.node+": ")+msg.err.Error())
+		} else {
+			m.messages = append(m.messages, styleToolMsg.Render("  ⚙ left shell on "+msg.node))
+		}
+		m.viewport.SetContent(strings.Join(m.messages, "\n"))
+		m.viewport.GotoBottom()
+		return m, nil
+
 	case error:
 		m.err = msg
 		return m, tea.Quit
@@ -98,18 +166,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(tiCmd, vpCmd)
 }
 
+// shellExited reports the end of a shell opened with /shell.
+type shellExited struct {
+	node string
+	err  error
+}
+
+// shell hands the terminal over to a shell on the named node.
+func (m *model) shell(name string) tea.Cmd {
+	n, ok := m.nodes[name]
+	if !ok {
+		names := make([]string, 0, len(m.nodes))
+		for k := range m.nodes {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		msg := "no node " + strconv.Quote(name)
+		if len(names) > 0 {
+			msg += " (configured: " + strings.Join(names, ", ") + ")"
+		}
+		m.messages = append(m.messages, styleErrorMsg.Render("Shell: ")+msg)
+		m.viewport.SetContent(strings.Join(m.messages, "\n"))
+		m.viewport.GotoBottom()
+		return nil
+	}
+	return tea.Exec(NewShell(m.ssh.Client(name, n)), func(err error) tea.Msg {
+		return shellExited{node: name, err: err}
+	})
+}
+
 func (m model) View() string {
+	status := "(ctrl+c to quit, /shell <node> for a shell)"
+	if m.sessionID != "" {
+		status += " · session " + m.sessionID
+	}
 	return fmt.Sprintf(
 		"%s\n\n%s\n\n%s\n%s",
 		styleHeader.Render("POE — RAVEN HOTEL"),
 		m.viewport.View(),
 		m.textinput.View(),
-		styleStatusBar.Render("(ctrl+c to quit)"),
+		styleStatusBar.Render(status),
 	)
 }
 
-func Run(conn *websocket.Conn) error {
-	p := tea.NewProgram(NewModel(conn), tea.WithAltScreen())
+func Run(conn *protocol.Conn, welcome protocol.Welcome, nodes map[string]config.NodeConfig) error {
+	m := NewModel(conn, welcome, nodes)
+	defer m.ssh.Close()
+	p := tea.NewProgram(m, tea.WithAltScreen())
 	_, err := p.Run()
 	return err
 }
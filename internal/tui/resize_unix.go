@@ -0,0 +1,14 @@
+//go:build !windows
+
+package tui
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize relays terminal size changes to c.
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
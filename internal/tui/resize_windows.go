@@ -0,0 +1,6 @@
+package tui
+
+import "os"
+
+// notifyResize does nothing; Windows consoles do not signal size changes.
+func notifyResize(c chan<- os.Signal) {}
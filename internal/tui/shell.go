@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/fjrt/poeai/internal/ssh"
+	"github.com/muesli/cancelreader"
+)
+
+// Shell connects the terminal to a login shell on a node until it exits.
+// It implements tea.ExecCommand, so that the chat can hand the terminal
+// over to it.
+type Shell struct {
+	client *ssh.Client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// NewShell returns a shell on the node of client, on the process's terminal.
+func NewShell(client *ssh.Client) *Shell {
+	return &Shell{client: client, stdin: os.Stdin, stdout: os.Stdout}
+}
+
+func (s *Shell) SetStdin(r io.Reader)  { s.stdin = r }
+func (s *Shell) SetStdout(w io.Writer) { s.stdout = w }
+func (s *Shell) SetStderr(io.Writer)   {} // the terminal merges stderr into stdout
+
+// Run attaches to the shell. The terminal is switched to raw mode, so that
+// keys like ctrl+c reach the remote shell, and size changes are passed on.
+func (s *Shell) Run() error {
+	// Reads from stdin are canceled when the shell exits, so that they do
+	// not swallow the next key meant for the chat.
+	in, err := cancelreader.NewReader(s.stdin)
+	if err != nil {
+		return err
+	}
+	defer in.Cancel()
+
+	out, _ := s.stdout.(*os.File)
+	size := func() (int, int) {
+		if out != nil {
+			if w, h, err := term.GetSize(out.Fd()); err == nil {
+				return w, h
+			}
+		}
+		return 80, 24
+	}
+	width, height := size()
+	termName := os.Getenv("TERM")
+
+	// Connect before switching to raw mode: the key's passphrase may have to
+	// be asked for.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := s.client.Start(ctx, "", ssh.StartOptions{
+		Stdin: in,
+		PTY:   &ssh.PTY{Term: termName, Width: width, Height: height},
+	})
+	if err != nil {
+		return err
+	}
+
+	if f, ok := s.stdin.(*os.File); ok && term.IsTerminal(f.Fd()) {
+		state, err := term.MakeRaw(f.Fd())
+		if err != nil {
+			cancel()
+			return err
+		}
+		defer term.Restore(f.Fd(), state)
+	}
+
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	go func() {
+		for range resized {
+			p.Resize(size())
+		}
+	}()
+
+	for chunk := range p.Output {
+		s.stdout.Write(chunk.Data)
+	}
+	_, err = p.Wait()
+	signal.Stop(resized)
+	close(resized)
+	return err
+}
@@ -9,6 +9,7 @@ var (
 	colorText     = lipgloss.Color("#e2e2e2")
 	colorPoeText  = lipgloss.Color("#c4b5fd")
 	colorUserText = lipgloss.Color("#6ee7b7")
+	colorError    = lipgloss.Color("#f87171")
 
 	styleHeader = lipgloss.NewStyle().
 			Foreground(colorAccent).
@@ -25,6 +26,8 @@ var (
 			BorderForeground(colorAccent).
 			Padding(0, 1)
 
-	stylePoeMsg  = lipgloss.NewStyle().Foreground(colorPoeText)
-	styleUserMsg = lipgloss.NewStyle().Foreground(colorUserText)
+	stylePoeMsg   = lipgloss.NewStyle().Foreground(colorPoeText)
+	styleUserMsg  = lipgloss.NewStyle().Foreground(colorUserText)
+	styleErrorMsg = lipgloss.NewStyle().Foreground(colorError)
+	styleToolMsg  = lipgloss.NewStyle().Foreground(colorDim).Italic(true)
 )